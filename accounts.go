@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/config"
+	"github.com/Edouard127/redditplacebot/util"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// accounts manages the account store, with the list, add, remove, status and migrate subcommands
+func accounts(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: accounts <list|add|remove|status|migrate> [flags]")
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "list":
+		return accountsList(args[1:])
+	case "add":
+		return accountsAdd(args[1:])
+	case "remove":
+		return accountsRemove(args[1:])
+	case "status":
+		return accountsStatus(args[1:])
+	case "migrate":
+		return accountsMigrate(args[1:])
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown accounts command %q, expected list, add, remove, status or migrate\n", args[0])
+	return exitUsage
+}
+
+// loadAccounts loads the config and the accounts, a missing account store is an empty list when allowed
+func loadAccounts(flags *flag.FlagSet, args []string, allowMissing bool) (*config.Config, client.AccountStore, []*client.Client, int) {
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return nil, nil, nil, exitUsage
+	}
+
+	store, clients, code := openAccounts(cfg, allowMissing)
+	return cfg, store, clients, code
+}
+
+// openAccounts reads the account store of the config, a missing account store is an empty list when allowed
+func openAccounts(cfg *config.Config, allowMissing bool) (client.AccountStore, []*client.Client, int) {
+	store, clients, err := readClients(cfg)
+	if err != nil {
+		if allowMissing && store != nil && errors.Is(err, os.ErrNotExist) {
+			return store, nil, exitOK
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, exitFailure
+	}
+
+	return store, clients, exitOK
+}
+
+func accountsList(args []string) int {
+	_, _, clients, code := loadAccounts(flag.NewFlagSet("accounts list", flag.ExitOnError), args, false)
+	if code != exitOK {
+		return code
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tPASSWORD\tTOKEN\tCOOKIES")
+	for _, c := range clients {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", c.Username, yesNo(c.Password != ""), yesNo(c.AccessToken != ""), len(c.Cookies))
+	}
+	w.Flush()
+
+	return exitOK
+}
+
+func accountsAdd(args []string) int {
+	flags := flag.NewFlagSet("accounts add", flag.ExitOnError)
+	username := flags.String("user", "", "Username of the account")
+	password := flags.String("password", "", "Password of the account, read from stdin if empty")
+
+	settings := config.RegisterFlags(flags)
+
+	flags.Parse(args) // The username is checked before the passphrase is asked
+	if *username == "" {
+		fmt.Fprintln(os.Stderr, "The -user flag is required")
+		return exitUsage
+	}
+
+	cfg, err := settings.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return exitUsage
+	}
+
+	store, clients, code := openAccounts(cfg, true)
+	if code != exitOK {
+		return code
+	}
+
+	if pickClient(clients, *username) != nil {
+		fmt.Fprintf(os.Stderr, "%s is already in %s\n", *username, cfg.Accounts)
+		return exitFailure
+	}
+
+	if *password == "" {
+		if *password, err = prompt(fmt.Sprintf("Password of %s: ", *username)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	clients = append(clients, &client.Client{Username: *username, Password: *password})
+	if err := store.Save(clients); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	fmt.Printf("Added %s, log it in with the login command\n", *username)
+	return exitOK
+}
+
+func accountsRemove(args []string) int {
+	flags := flag.NewFlagSet("accounts remove", flag.ExitOnError)
+	cfg, store, clients, code := loadAccounts(flags, args, false)
+	if code != exitOK {
+		return code
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: accounts remove [flags] <username>...")
+		return exitUsage
+	}
+
+	remove := make(map[string]bool, flags.NArg())
+	for _, username := range flags.Args() {
+		remove[username] = true
+	}
+
+	kept := clients[:0]
+	for _, c := range clients {
+		if remove[c.Username] {
+			delete(remove, c.Username)
+			continue
+		}
+		kept = append(kept, c)
+	}
+
+	if len(remove) > 0 {
+		for username := range remove {
+			fmt.Fprintf(os.Stderr, "No account named %q in %s\n", username, cfg.Accounts)
+		}
+		return exitFailure
+	}
+
+	if err := store.Save(kept); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// accountsStatus shows the status of the accounts, with -check it asks the server first and saves what it says
+func accountsStatus(args []string) int {
+	flags := flag.NewFlagSet("accounts status", flag.ExitOnError)
+	username := flags.String("user", "", "Only show this account, every account if empty")
+	check := flags.Bool("check", false, "Ask the server for the cooldown of the accounts with a session, and update their status")
+
+	cfg, store, clients, code := loadAccounts(flags, args, false)
+	if code != exitOK {
+		return code
+	}
+
+	shown := clients
+	if *username != "" {
+		c := pickClient(clients, *username)
+		if c == nil {
+			fmt.Fprintf(os.Stderr, "No account named %q in %s\n", *username, cfg.Accounts)
+			return exitFailure
+		}
+		shown = []*client.Client{c}
+	}
+
+	if *check {
+		setupClients(cfg, shown, newLogger(cfg), nil, nil, util.NewRand(time.Now().UnixNano()))
+
+		for _, c := range shown {
+			if c.AccessToken == "" || c.CurrentStatus().Dead() {
+				continue
+			}
+
+			c.Setup()
+			result := c.GetCooldown()
+			c.Close()
+			if !c.Observe(result, time.Now()) && result.Kind != client.NoError {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", c.Username, result)
+			}
+		}
+
+		if err := store.Save(clients); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not save the accounts:", err)
+			return exitFailure
+		}
+	}
+
+	now := time.Now()
+	code = exitOK
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tSTATE\tSINCE\tNEXT PIXEL\tREASON")
+	for _, c := range shown {
+		status := c.CurrentStatus()
+		state := status.At(now)
+
+		since, next := "-", "-"
+		if !status.Since.IsZero() {
+			since = status.Since.Local().Format(time.DateTime)
+		}
+		if state == client.StateCoolingDown {
+			next = status.Until.Sub(now).Round(time.Second).String()
+		}
+		if c.AccessToken == "" && !status.Dead() {
+			state = "no-session"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Username, state, since, next, status.Reason)
+
+		if status.Dead() || status.Broken() || c.AccessToken == "" {
+			code = exitFailure // Some account can't place
+		}
+	}
+	w.Flush()
+
+	return code
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// accountsMigrate moves the accounts of a plaintext users.json into the encrypted account store
+func accountsMigrate(args []string) int {
+	flags := flag.NewFlagSet("accounts migrate", flag.ExitOnError)
+	from := flags.String("from", "data/users.json", "Plaintext accounts file to migrate")
+	remove := flags.Bool("delete", false, "Delete the plaintext file once the accounts are migrated")
+
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return exitUsage
+	}
+
+	clients, err := (&client.PlainStore{Path: *from}).Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	if _, err = os.Stat(cfg.Accounts); err == nil {
+		fmt.Fprintf(os.Stderr, "%s already exists, add the accounts to it with accounts add\n", cfg.Accounts)
+		return exitFailure
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	if err = store.Save(clients); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	if migrated, err := store.Load(); err != nil || len(migrated) != len(clients) { // Never delete the only copy of the accounts
+		fmt.Fprintf(os.Stderr, "%s could not be read back, %s is kept: %v\n", cfg.Accounts, *from, err)
+		return exitFailure
+	}
+
+	fmt.Printf("Migrated %d accounts from %s to %s\n", len(clients), *from, cfg.Accounts)
+
+	if *remove {
+		if err = os.Remove(*from); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	} else {
+		fmt.Printf("%s still holds the passwords in clear, delete it once you checked the migration\n", *from)
+	}
+
+	return exitOK
+}
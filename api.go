@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/events"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ControlServer is the HTTP API controlling the running bot, every request needs the token
+type ControlServer struct {
+	*zap.Logger
+	worker   *Worker
+	board    *board.Board
+	accounts []*client.Client // Every account of the store, the ones that are not in the worker too
+	history  *placementLog
+	Token    string
+}
+
+// NewControlServer returns the API of the worker, a random token is made when the given one is empty
+func NewControlServer(logger *zap.Logger, k *Worker, b *board.Board, accounts []*client.Client, placements *placementLog, token string) *ControlServer {
+	if token == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			panic(err)
+		}
+		token = hex.EncodeToString(random)
+	}
+
+	return &ControlServer{Logger: logger, worker: k, board: b, accounts: accounts, history: placements, Token: token}
+}
+
+// ListenAndServe serves the API on the address until it fails
+func (s *ControlServer) ListenAndServe(addr string) error {
+	s.Info("Serving the control API", zap.String("address", addr))
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+func (s *ControlServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", s.get(s.status))
+	mux.HandleFunc("/api/templates", s.get(s.templates))
+	mux.HandleFunc("/api/templates/", s.post(s.template))
+	mux.HandleFunc("/api/accounts", s.get(s.accountList))
+	mux.HandleFunc("/api/accounts/", s.post(s.account))
+	mux.HandleFunc("/api/placements", s.get(s.placements))
+	mux.HandleFunc("/api/events", s.stream)
+
+	return s.authorize(mux)
+}
+
+// authorize refuses the requests without the token, it's in the Authorization header. EventSource can't set headers,
+// so the events stream also takes it from the token parameter, the other endpoints don't so it stays out of the logs
+func (s *ControlServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" && r.URL.Path == "/api/events" {
+			token = r.URL.Query().Get("token")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or wrong token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *ControlServer) get(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func (s *ControlServer) post(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+type accountStatus struct {
+	Username string              `json:"username"`
+	State    client.State        `json:"state"`
+	Reason   string              `json:"reason,omitempty"`
+	Since    time.Time           `json:"since"`
+	Joined   bool                `json:"joined"` // Takes part in the worker
+	Paused   bool                `json:"paused"`
+	Next     *time.Time          `json:"next,omitempty"` // When it can place again, only for the joined accounts
+	Last     *events.PixelPlaced `json:"last,omitempty"` // The last placement
+	Stats    AccountStats        `json:"stats"`
+}
+
+type templateStatus struct {
+	Name       string      `json:"name"`
+	Image      string      `json:"image"`
+	Origin     board.Point `json:"origin"`
+	Strategy   string      `json:"strategy"`
+	Enabled    bool        `json:"enabled"`
+	Total      int         `json:"total"`
+	Mismatched int         `json:"mismatched"`
+	Completion float64     `json:"completion"`
+}
+
+func (s *ControlServer) status(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Time       time.Time        `json:"time"`
+		Accounts   []accountStatus  `json:"accounts"`
+		Templates  []templateStatus `json:"templates"`
+		Queue      int              `json:"queue"` // Mismatched pixels waiting for a client
+		Controller string           `json:"controller"`
+		LastFrame  time.Time        `json:"lastFrame"`
+	}{
+		Time:       time.Now(),
+		Accounts:   s.accountStatuses(),
+		Templates:  s.templateStatuses(),
+		Queue:      s.worker.QueueLength(),
+		Controller: controllerName(s.board.Controller()),
+		LastFrame:  s.board.LastFrame(),
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *ControlServer) templates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.templateStatuses())
+}
+
+// template handles /api/templates/reload and /api/templates/{name}/enable or disable
+func (s *ControlServer) template(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/templates/")
+	if path == "reload" {
+		if err := s.worker.ReloadTemplates(); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		s.Info("Templates reloaded from the control API")
+		writeJSON(w, http.StatusOK, s.templateStatuses())
+		return
+	}
+
+	name, action, _ := strings.Cut(path, "/")
+	var t *board.Template
+	for _, template := range s.board.Templates() {
+		if template.Name == name {
+			t = template
+		}
+	}
+	if t == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("there is no template %q", name))
+		return
+	}
+
+	switch action {
+	case "enable", "disable":
+		s.worker.SetTemplateEnabled(t, action == "enable")
+		s.Info("Template changed from the control API", zap.String("template", name), zap.String("action", action))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown action %q, use enable or disable", action))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.templateStatus(t))
+}
+
+func (s *ControlServer) accountList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.accountStatuses())
+}
+
+// account handles /api/accounts/{name}/pause and resume
+func (s *ControlServer) account(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/accounts/"), "/")
+
+	var c *client.Client
+	for _, account := range s.accounts {
+		if account.Username == name {
+			c = account
+		}
+	}
+	if c == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("there is no account %q", name))
+		return
+	}
+
+	var changed bool
+	switch action {
+	case "pause":
+		changed = s.worker.Pause(c)
+	case "resume":
+		changed = s.worker.Resume(c)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown action %q, use pause or resume", action))
+		return
+	}
+
+	if !changed {
+		writeError(w, http.StatusConflict, fmt.Sprintf("could not %s %s, it's not in the worker or already done", action, name))
+		return
+	}
+
+	s.Info("Account changed from the control API", zap.String("username", name), zap.String("action", action))
+	writeJSON(w, http.StatusOK, s.accountStatus(c))
+}
+
+// placements returns the last placements, 100 unless the limit parameter says otherwise
+func (s *ControlServer) placements(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, s.history.Last(limit))
+}
+
+// stream sends the events of the bus as server-sent events until the client goes away
+func (s *ControlServer) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	sub := events.Subscribe[events.Event](s.worker.Bus(), "api", 64)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-sub.C:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name(), data)
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *ControlServer) accountStatuses() []accountStatus {
+	return describeAccounts(s.worker, s.history, s.accounts)
+}
+
+func (s *ControlServer) accountStatus(c *client.Client) accountStatus {
+	return describeAccounts(s.worker, s.history, []*client.Client{c})[0]
+}
+
+// describeAccounts returns the status of the accounts in the worker
+func describeAccounts(k *Worker, placements *placementLog, accounts []*client.Client) []accountStatus {
+	members := make(map[*client.Client]bool)
+	for _, c := range k.Clients() {
+		members[c] = true
+	}
+
+	statuses := make([]accountStatus, len(accounts))
+	for i, c := range accounts {
+		status := c.CurrentStatus()
+		statuses[i] = accountStatus{
+			Username: c.Username,
+			State:    status.State,
+			Reason:   status.Reason,
+			Since:    status.Since,
+			Joined:   members[c],
+			Paused:   k.Paused(c),
+			Stats:    placements.Stats(c.Username),
+		}
+
+		if members[c] {
+			next := k.NextAvailable(c)
+			statuses[i].Next = &next
+		}
+		if last, ok := placements.Account(c.Username); ok {
+			statuses[i].Last = &last
+		}
+	}
+
+	return statuses
+}
+
+func (s *ControlServer) templateStatuses() []templateStatus {
+	return describeTemplates(s.worker, s.board.Progress())
+}
+
+func (s *ControlServer) templateStatus(t *board.Template) templateStatus {
+	for _, p := range s.board.Progress() {
+		if p.Template == t {
+			return describeTemplates(s.worker, []board.Progress{p})[0]
+		}
+	}
+	return describeTemplates(s.worker, []board.Progress{{Template: t}})[0]
+}
+
+// describeTemplates returns the status of the templates in the worker
+func describeTemplates(k *Worker, progress []board.Progress) []templateStatus {
+	statuses := make([]templateStatus, len(progress))
+	for i, p := range progress {
+		statuses[i] = templateStatus{
+			Name:       p.Template.Name,
+			Image:      p.Template.Path,
+			Origin:     p.Template.Origin,
+			Strategy:   p.Template.Strategy,
+			Enabled:    k.TemplateEnabled(p.Template),
+			Total:      p.Total,
+			Mismatched: p.Mismatched,
+			Completion: p.Completion(),
+		}
+	}
+	return statuses
+}
+
+// controllerName names the client feeding the board
+func controllerName(c board.Controller) string {
+	switch c := c.(type) {
+	case nil:
+		return ""
+	case *client.Client:
+		return c.Username
+	case *client.Observer:
+		return "observer"
+	default:
+		return fmt.Sprintf("%T", c)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlServerAuthorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header string
+		want   int
+	}{
+		{name: "header", method: http.MethodPost, target: "/api/accounts/alice/pause", header: "Bearer secret", want: http.StatusOK},
+		{name: "no token", method: http.MethodGet, target: "/api/status", want: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, target: "/api/status", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "parameter on the events stream", method: http.MethodGet, target: "/api/events?token=secret", want: http.StatusOK},
+		{name: "parameter on another endpoint", method: http.MethodPost, target: "/api/accounts/alice/pause?token=secret", want: http.StatusUnauthorized},
+	}
+
+	s := &ControlServer{Token: "secret"}
+	handler := s.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
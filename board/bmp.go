@@ -2,6 +2,7 @@ package board
 
 import (
 	"errors"
+	"fmt"
 	"github.com/Edouard127/redditplacebot/util"
 	"github.com/sergeymakinen/go-bmp"
 	"os"
@@ -25,12 +26,12 @@ type Point struct {
 }
 
 var canvasConfiguration = map[int]util.Pair[int, int]{
-	0: {0, 0},
-	1: {1000, 0},
-	2: {2000, 0},
-	3: {0, 1000},
-	4: {1000, 1000},
-	5: {2000, 1000},
+	0: {First: 0, Second: 0},
+	1: {First: 1000, Second: 0},
+	2: {First: 2000, Second: 0},
+	3: {First: 0, Second: 1000},
+	4: {First: 1000, Second: 1000},
+	5: {First: 2000, Second: 1000},
 }
 
 func (p Point) ToPlacePoint(canvas int) Point {
@@ -40,8 +41,9 @@ func (p Point) ToPlacePoint(canvas int) Point {
 	}
 }
 
-func LoadBMP(offsetX, offsetY int) *BMPImage {
-	f, err := os.Open("../data/image.bmp")
+// LoadBMP reads the image quantized to the active colors, it panics if the image can't be read
+func LoadBMP(path string, offsetX, offsetY int) *BMPImage {
+	image, err := ReadBMP(path, offsetX, offsetY)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			panic("Please add an image in the data folder")
@@ -49,27 +51,38 @@ func LoadBMP(offsetX, offsetY int) *BMPImage {
 		panic(err)
 	}
 
+	return image
+}
+
+// ReadBMP reads the image quantized to the active colors, with its top left corner at the offset
+func ReadBMP(path string, offsetX, offsetY int) (*BMPImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
 	image, err := bmp.Decode(f)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 
 	bmpImage := &BMPImage{
 		Width:  image.Bounds().Dx(),
 		Height: image.Bounds().Dy(),
-		Colors: make(map[Point]Color, image.Bounds().Dx()),
+		Colors: make(map[Point]Color, image.Bounds().Dx()*image.Bounds().Dy()),
 	}
 
 	for x := 0; x < image.Bounds().Dx(); x++ {
 		for y := 0; y < image.Bounds().Dy(); y++ {
-			r, g, b, _ := image.At(x, y).RGBA()
-			bmpImage.Colors[Point{x + offsetX, y + offsetY}] = *closestColor(Color{
-				R: uint8(r),
-				G: uint8(g),
-				B: uint8(b),
+			r, g, b, _ := image.At(image.Bounds().Min.X+x, image.Bounds().Min.Y+y).RGBA() // 16 bits per channel
+			bmpImage.Colors[Point{x + offsetX, y + offsetY}] = closestColor(Color{
+				R: uint8(r >> 8),
+				G: uint8(g >> 8),
+				B: uint8(b >> 8),
 			})
 		}
 	}
 
-	return bmpImage
+	return bmpImage, nil
 }
@@ -1,39 +1,100 @@
 package board
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/util"
 	"github.com/Edouard127/redditplacebot/web"
+	"go.uber.org/zap"
+	"image"
 	"image/png"
-	"math"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Controller is the client feeding the canvas information to the board
+type Controller interface {
+	Info(msg string, fields ...zap.Field)
+}
+
 type Board struct {
 	mu           sync.Mutex
-	Start, End   Point
-	RequiredData *BMPImage      // The image to draw on the canvas
-	CurrentData  *BMPImage      // Only the canvas data between Start and End, so we don't flood the memory and the cpu
-	controller   *client.Client // Only one client will control the information to the board, so we don't flood the memory and the cpu
+	templates    []*Template
+	owners       map[Point]*Template // The template drawing each pixel of RequiredData
+	RequiredData *BMPImage           // The templates to draw on the canvas, the last ones are drawn over the first ones
+	CurrentData  *BMPImage           // Only the canvas data of the templates and the observed pixels, so we don't flood the memory and the cpu
+	controller   Controller          // Only one client will control the information to the board, so we don't flood the memory and the cpu
+	observed     map[Point]struct{}  // Pixels we follow on the canvas without drawing them
+	listeners    []func(changed map[Point]Color)
+	lastFrame    time.Time // When the controller last fed the board
+	Clock        util.Clock
 }
 
-func NewBoard(start Point) *Board {
-	return &Board{Start: start}
+func NewBoard(templates ...*Template) *Board {
+	return &Board{templates: templates}
 }
 
-func (b *Board) GetCanvasIndex(at Point) int {
-	at.X += 1500
+func (b *Board) Templates() []*Template {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	if at.X >= 0 && at.X < 3000 {
-		if at.Y >= 0 {
-			return int(at.X)/1000 + 3
-		} else {
-			return int(at.X) / 1000
-		}
+	return append([]*Template{}, b.templates...)
+}
+
+// TemplateOf returns the template drawing the pixel, nil if none does
+func (b *Board) TemplateOf(at Point) *Template {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.owners[at]
+}
+
+// RequiredColor returns the color the templates want at the given point, if one of them draws it
+func (b *Board) RequiredColor(at Point) (Color, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.RequiredData == nil {
+		return Color{}, false
+	}
+
+	color, ok := b.RequiredData.Colors[at]
+	return color, ok
+}
+
+// ErrOffCanvas is returned for points that are not on any canvas
+var ErrOffCanvas = errors.New("off the canvas")
+
+// InCanvas reports if the point is on one of the canvases
+func InCanvas(at Point) bool {
+	return at.X >= -1500 && at.X < 1500 && at.Y >= -1000 && at.Y < 1000
+}
+
+// GetCanvasIndex returns the canvas the point is on, false when it's off every canvas
+func (b *Board) GetCanvasIndex(at Point) (int, bool) {
+	if !InCanvas(at) {
+		return 0, false
+	}
+
+	at.X += 1500
+	if at.Y >= 0 {
+		return at.X/1000 + 3, true
 	}
+	return at.X / 1000, true
+}
 
-	panic(fmt.Sprintf("Point %v is not in the canvas", at))
+// CheckBounds fails when a rectangle of the given size at the origin goes off the canvas
+func CheckBounds(origin Point, width, height int) error {
+	end := Point{X: origin.X + width - 1, Y: origin.Y + height - 1}
+	if !InCanvas(origin) || !InCanvas(end) {
+		return fmt.Errorf("%v to %v is %w, which goes from (-1500, -1000) to (1499, 999)", origin, end, ErrOffCanvas)
+	}
+	return nil
 }
 
 func (b *Board) GetDifferentData() map[Point]Color {
@@ -42,6 +103,10 @@ func (b *Board) GetDifferentData() map[Point]Color {
 
 	differentData := make(map[Point]Color, 0)
 
+	if b.RequiredData == nil || b.CurrentData == nil {
+		return differentData
+	}
+
 	for point, color := range b.RequiredData.Colors {
 		if b.CurrentData.Colors[point] != color {
 			differentData[point] = color
@@ -51,18 +116,99 @@ func (b *Board) GetDifferentData() map[Point]Color {
 	return differentData
 }
 
-func (b *Board) SetController(controller *client.Client) {
+// Listen registers a function called with the pixels of the region that changed after every canvas frame
+func (b *Board) Listen(fn func(changed map[Point]Color)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.listeners = append(b.listeners, fn)
+}
+
+// Observe makes the board follow the canvas between start and end (exclusive), even outside the image
+func (b *Board) Observe(start, end Point) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.observed == nil {
+		b.observed = make(map[Point]struct{})
+	}
+
+	for x := start.X; x < end.X; x++ {
+		for y := start.Y; y < end.Y; y++ {
+			b.observed[Point{x, y}] = struct{}{}
+		}
+	}
+}
+
+// CurrentColor returns the color of the canvas at the given point, if we know it
+func (b *Board) CurrentColor(at Point) (Color, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.CurrentData == nil {
+		return Color{}, false
+	}
+
+	color, ok := b.CurrentData.Colors[at]
+	return color, ok
+}
+
+// SetController gives the control of the board to the client, unless another client already has it
+func (b *Board) SetController(controller Controller) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if b.controller == nil {
 		b.controller = controller
-		b.controller.Logger.Info("Controller changed")
+		b.controller.Info("Controller changed")
+		b.lastFrame = b.clock().Now() // Give the new controller time to subscribe
 	}
 }
 
-func (b *Board) checkForController(c *client.Client) bool {
+func (b *Board) Controller() Controller {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.controller
+}
+
+// LastFrame returns when the controller last fed the board, or when it got the control
+func (b *Board) LastFrame() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.lastFrame
+}
+
+func (b *Board) clock() util.Clock {
+	if b.Clock == nil {
+		return util.RealClock
+	}
+
+	return b.Clock
+}
+
+// ReleaseController takes the control of the board away from the client, it reports if the client had it
+func (b *Board) ReleaseController(c Controller) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.controller != c || c == nil {
+		return false
+	}
+
+	b.controller = nil
+	return true
+}
+
+func (b *Board) checkForController(c Controller) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	return b.controller == c && b.controller != nil
 }
 
-func (b *Board) SetColors(c *client.Client, colors []web.SubscribeColor) {
+func (b *Board) SetColors(c Controller, colors []web.SubscribeColor) {
 	if !b.checkForController(c) {
 		return
 	}
@@ -72,20 +218,176 @@ func (b *Board) SetColors(c *client.Client, colors []web.SubscribeColor) {
 }
 
 // loadImage should be called after we're connected to the websocket and received the SubscribedData
-func (b *Board) loadImage(c *client.Client) {
+func (b *Board) loadImage(c Controller) {
 	if !b.checkForController(c) {
 		return
 	}
 
-	b.RequiredData = LoadBMP(b.Start.X, b.Start.Y)
-	b.End = Point{X: b.RequiredData.Width, Y: b.RequiredData.Height}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.templates) == 0 {
+		return
+	}
+
+	for _, t := range b.templates {
+		t.Load()
+	}
+
+	b.compose()
+}
+
+// ReloadTemplates reads the images of the templates again, nothing changes if one of them can't be read
+func (b *Board) ReloadTemplates() error {
+	templates := b.Templates()
+
+	images := make([]*BMPImage, len(templates))
+	for i, t := range templates {
+		image, err := ReadBMP(t.Path, t.Origin.X, t.Origin.Y)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+		if err = CheckBounds(t.Origin, image.Width, image.Height); err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+		images[i] = image
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, t := range templates {
+		t.Image = images[i]
+	}
+	b.compose()
+
+	return nil
+}
+
+// compose draws the templates over each other into RequiredData, it must be called with the lock held
+func (b *Board) compose() {
+	required := &BMPImage{Colors: make(map[Point]Color)}
+	owners := make(map[Point]*Template)
+
+	for _, t := range b.templates {
+		if t.Image == nil {
+			continue
+		}
+
+		for point, color := range t.Image.Colors {
+			required.Colors[point] = color
+			owners[point] = t
+		}
+	}
+
+	b.RequiredData, b.owners = required, owners
 }
 
-func (b *Board) SetCurrentData(url string) {
+// Progress is how much of a template is drawn on the canvas
+type Progress struct {
+	Template   *Template
+	Total      int // Pixels of the template that are not covered by a later template
+	Mismatched int // Pixels that don't match the canvas, the unknown ones included
+}
+
+// Progress returns how much of every template is drawn, in the order of the templates
+func (b *Board) Progress() []Progress {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.downloadImage(url)
+	progress := make([]Progress, len(b.templates))
+	index := make(map[*Template]int, len(b.templates))
+	for i, t := range b.templates {
+		progress[i].Template = t
+		index[t] = i
+	}
+
+	for point, t := range b.owners {
+		p := &progress[index[t]]
+		p.Total++
+
+		if b.CurrentData == nil {
+			p.Mismatched++
+		} else if current, ok := b.CurrentData.Colors[point]; !ok || current != b.RequiredData.Colors[point] {
+			p.Mismatched++
+		}
+	}
+
+	return progress
+}
+
+// Completion is the share of the pixels that match the canvas
+func (p Progress) Completion() float64 {
+	if p.Total == 0 {
+		return 1
+	}
+
+	return float64(p.Total-p.Mismatched) / float64(p.Total)
+}
+
+// SetCurrentData applies a frame of the given canvas, full frames replace the region while diff frames only carry the changed pixels
+func (b *Board) SetCurrentData(c Controller, canvas int, url string, diff bool) error {
+	if !b.checkForController(c) {
+		return nil
+	}
+
+	b.mu.Lock()
+	following := len(b.observed) > 0 || b.RequiredData != nil
+	b.mu.Unlock()
+
+	var frame image.Image
+	if following { // The lock is not held while downloading, so a slow frame does not stop the readers of the board
+		var err error
+		if frame, err = downloadFrame(canvas, url); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	changed := b.applyFrame(canvas, frame, diff)
+	b.lastFrame = b.clock().Now()
+	b.mu.Unlock()
+
+	b.notify(changed)
+	return nil
+}
+
+// SetPixels changes pixels of the canvas directly, for canvases that don't come from the server
+func (b *Board) SetPixels(c Controller, pixels map[Point]Color) {
+	if !b.checkForController(c) {
+		return
+	}
+
+	b.mu.Lock()
+	if b.CurrentData == nil {
+		b.CurrentData = &BMPImage{Colors: make(map[Point]Color, len(pixels))}
+	}
+
+	changed := make(map[Point]Color)
+	for point, color := range pixels {
+		if current, ok := b.CurrentData.Colors[point]; !ok || current != color {
+			b.CurrentData.Colors[point] = color
+			changed[point] = color
+		}
+	}
+	b.lastFrame = b.clock().Now()
+	b.mu.Unlock()
+
+	b.notify(changed)
+}
+
+func (b *Board) notify(changed map[Point]Color) {
+	if len(changed) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	listeners := b.listeners
+	b.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(changed)
+	}
 }
 
 var Colors = map[int]Color{
@@ -123,16 +425,58 @@ var Colors = map[int]Color{
 	31: hexToRGB("#FFFFFF"), // White
 }
 
-var ActiveColors = make(map[int]Color, 0)
+// activeColors is the palette of the canvas, the map is never changed once stored so it can be read without a lock
+var (
+	activeColors     atomic.Pointer[map[int]Color]
+	activeColorsLock sync.Mutex // Serializes the writers
+)
+
+// ActiveColors returns the colors the canvas accepts by index, the map must not be changed
+func ActiveColors() map[int]Color {
+	if colors := activeColors.Load(); colors != nil {
+		return *colors
+	}
+	return nil
+}
+
+// Palette returns the given colors like the server sends them, every color if none is given
+func Palette(indexes ...int) []web.SubscribeColor {
+	if len(indexes) == 0 {
+		for index := range Colors {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+	}
 
+	palette := make([]web.SubscribeColor, 0, len(indexes))
+	for _, index := range indexes {
+		if color, ok := Colors[index]; ok {
+			palette = append(palette, web.SubscribeColor{Hex: color.Hex(), Index: index})
+		}
+	}
+
+	return palette
+}
+
+// SetActiveColors adds colors to the palette, the clients placing pixels keep reading the previous one until it's swapped
 func SetActiveColors(colors []web.SubscribeColor) {
+	activeColorsLock.Lock()
+	defer activeColorsLock.Unlock()
+
+	previous := ActiveColors()
+	palette := make(map[int]Color, len(previous)+len(colors))
+	for index, c := range previous {
+		palette[index] = c
+	}
 	for _, color := range colors {
-		ActiveColors[color.Index] = Colors[color.Index]
+		palette[color.Index] = Colors[color.Index]
 	}
+
+	activeColors.Store(&palette)
 }
 
 func GetColorIndex(color Color) int {
-	for index, c := range ActiveColors {
+	for index, c := range ActiveColors() {
 		if c == color {
 			return index
 		}
@@ -142,22 +486,55 @@ func GetColorIndex(color Color) int {
 
 func ImageColorConvert(image *BMPImage) *BMPImage {
 	for point, color := range image.Colors {
-		image.Colors[point] = *closestColor(color)
+		image.Colors[point] = closestColor(color)
 	}
 	return image
 }
 
-func closestColor(color Color) *Color {
-	var closestColor *Color
-	var closestDistance = math.MaxFloat64
-	for _, c := range ActiveColors {
-		distance := float64((color.R-c.R)*(color.R-c.R) + (color.G-c.G)*(color.G-c.G) + (color.B-c.B)*(color.B-c.B))
-		if closestDistance == 0 || distance < closestDistance {
-			closestDistance = distance
-			closestColor = &c
+// closestColor returns the active color nearest to the given one, the ties go to the lowest index
+func closestColor(color Color) Color {
+	palette := ActiveColors()
+	indexes := make([]int, 0, len(palette))
+	for index := range palette {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	closest, closestDistance := color, -1
+	for _, index := range indexes {
+		c := palette[index]
+		dr, dg, db := int(color.R)-int(c.R), int(color.G)-int(c.G), int(color.B)-int(c.B)
+
+		if distance := dr*dr + dg*dg + db*db; closestDistance < 0 || distance < closestDistance {
+			closest, closestDistance = c, distance
 		}
 	}
-	return closestColor
+
+	return closest
+}
+
+func (c Color) Hex() string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// MarshalJSON writes the color like "#FF4500"
+func (c Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Hex())
+}
+
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var hex string
+	if err := json.Unmarshal(data, &hex); err != nil {
+		return err
+	}
+
+	var r, g, b uint8
+	if n, err := fmt.Sscanf(strings.TrimPrefix(hex, "#"), "%02x%02x%02x", &r, &g, &b); err != nil || n != 3 {
+		return fmt.Errorf("invalid color %q", hex)
+	}
+
+	*c = Color{r, g, b}
+	return nil
 }
 
 func hexToRGB(hexColor string) Color {
@@ -174,34 +551,74 @@ func hexToRGB(hexColor string) Color {
 	return Color{r, g, b}
 }
 
-func (b *Board) downloadImage(url string) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		panic(err)
+// region returns every pixel we follow on the canvas
+func (b *Board) region() map[Point]struct{} {
+	region := make(map[Point]struct{}, len(b.observed))
+	for point := range b.observed {
+		region[point] = struct{}{}
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	if b.RequiredData != nil {
+		for point := range b.RequiredData.Colors {
+			region[point] = struct{}{}
+		}
+	}
+
+	return region
+}
+
+// frameClient downloads the canvas frames, a frame that takes longer than the timeout is dropped
+var frameClient = &http.Client{Timeout: 30 * time.Second}
+
+func downloadFrame(canvas int, url string) (image.Image, error) {
+	resp, err := frameClient.Get(url)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	image, err := png.Decode(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download canvas %d: %s", canvas, resp.Status)
+	}
+
+	frame, err := png.Decode(resp.Body)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("decode canvas %d: %w", canvas, err)
+	}
+
+	return frame, nil
+}
+
+// applyFrame copies the pixels of the region that are on the canvas of the frame, it must be called with the lock held
+func (b *Board) applyFrame(canvas int, frame image.Image, diff bool) map[Point]Color {
+	region := b.region()
+	if len(region) == 0 || frame == nil {
+		return nil
 	}
 
-	bmpImage := &BMPImage{
-		Width:  image.Bounds().Dx(),
-		Height: image.Bounds().Dy(),
-		Colors: make(map[Point]Color, image.Bounds().Dx()),
+	if b.CurrentData == nil {
+		b.CurrentData = &BMPImage{Colors: make(map[Point]Color, len(region))}
 	}
 
-	for x := b.Start.X; x < b.End.X; x++ {
-		for y := b.Start.Y; y < b.End.Y; y++ {
-			r, g, b, _ := image.At(x, y).RGBA()
-			bmpImage.Colors[Point{x, y}] = Color{uint8(r), uint8(g), uint8(b)}
+	changed := make(map[Point]Color)
+
+	for point := range region {
+		if index, ok := b.GetCanvasIndex(point); !ok || index != canvas {
+			continue
+		}
+
+		at := point.ToPlacePoint(canvas)
+		r, g, b2, a := frame.At(at.X, at.Y).RGBA()
+		if diff && a == 0 {
+			continue
+		}
+
+		color := Color{uint8(r), uint8(g), uint8(b2)}
+		if current, ok := b.CurrentData.Colors[point]; !ok || current != color {
+			b.CurrentData.Colors[point] = color
+			changed[point] = color
 		}
 	}
 
-	b.CurrentData = bmpImage
+	return changed
 }
@@ -0,0 +1,52 @@
+package board
+
+import (
+	"image"
+	"image/color"
+)
+
+// Render draws the templates and the canvas under them, the top left pixel of the images is at origin on the canvas,
+// the pixels we don't know are transparent. The images are nil until the templates are loaded
+func (b *Board) Render() (origin Point, required, current *image.NRGBA) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.RequiredData == nil || len(b.RequiredData.Colors) == 0 {
+		return Point{}, nil, nil
+	}
+
+	first := true
+	var min, max Point
+	for point := range b.RequiredData.Colors {
+		if first || point.X < min.X {
+			min.X = point.X
+		}
+		if first || point.Y < min.Y {
+			min.Y = point.Y
+		}
+		if first || point.X >= max.X {
+			max.X = point.X + 1
+		}
+		if first || point.Y >= max.Y {
+			max.Y = point.Y + 1
+		}
+		first = false
+	}
+
+	bounds := image.Rect(0, 0, max.X-min.X, max.Y-min.Y)
+	required, current = image.NewNRGBA(bounds), image.NewNRGBA(bounds)
+
+	for point, c := range b.RequiredData.Colors {
+		required.SetNRGBA(point.X-min.X, point.Y-min.Y, color.NRGBA{R: c.R, G: c.G, B: c.B, A: 255})
+	}
+
+	if b.CurrentData != nil {
+		for point, c := range b.CurrentData.Colors {
+			if point.X >= min.X && point.X < max.X && point.Y >= min.Y && point.Y < max.Y {
+				current.SetNRGBA(point.X-min.X, point.Y-min.Y, color.NRGBA{R: c.R, G: c.G, B: c.B, A: 255})
+			}
+		}
+	}
+
+	return min, required, current
+}
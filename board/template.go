@@ -0,0 +1,81 @@
+package board
+
+import (
+	"fmt"
+	"github.com/sergeymakinen/go-bmp"
+	"os"
+)
+
+// Template is an image drawn on the canvas with its top left corner at Origin
+type Template struct {
+	Name     string
+	Path     string
+	Origin   Point
+	Strategy string    // How the worker picks the next pixel of this template
+	Image    *BMPImage // Loaded once the active colors are known
+}
+
+func NewTemplate(name, path string, origin Point, strategy string) *Template {
+	return &Template{Name: name, Path: path, Origin: origin, Strategy: strategy}
+}
+
+func (t *Template) Load() {
+	t.Image = LoadBMP(t.Path, t.Origin.X, t.Origin.Y)
+}
+
+// CheckBounds reads the size of the image and fails when the template goes off the canvas
+func (t *Template) CheckBounds() error {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config, err := bmp.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.Path, err)
+	}
+
+	return CheckBounds(t.Origin, config.Width, config.Height)
+}
+
+// Read loads the image like Load, but returns an error when it can't be read
+func (t *Template) Read() (err error) {
+	t.Image, err = ReadBMP(t.Path, t.Origin.X, t.Origin.Y)
+	return
+}
+
+func (t *Template) Contains(at Point) bool {
+	if t.Image == nil {
+		return false
+	}
+
+	_, ok := t.Image.Colors[at]
+	return ok
+}
+
+// Center returns the middle of the template on the canvas
+func (t *Template) Center() Point {
+	if t.Image == nil {
+		return t.Origin
+	}
+
+	return Point{X: t.Origin.X + t.Image.Width/2, Y: t.Origin.Y + t.Image.Height/2}
+}
+
+// Edge reports if the pixel is on the outline of a shape of the template, its border or a change of color
+func (t *Template) Edge(at Point) bool {
+	color, ok := t.Image.Colors[at]
+	if !ok {
+		return false
+	}
+
+	for _, d := range []Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		neighbour, ok := t.Image.Colors[Point{at.X + d.X, at.Y + d.Y}]
+		if !ok || neighbour != color {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/web"
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"strings"
+	"time"
+)
+
+// Credentials are what an Authenticator logs an account in with
+type Credentials struct {
+	Username string
+	Password string
+	Cookies  []*proto.NetworkCookie // The session of a previous login, the password is only used without one
+}
+
+// Session is what a login gives back
+type Session struct {
+	Token   string
+	Cookies []*proto.NetworkCookie
+}
+
+// Authenticator logs an account in and gets the access token of r/place
+type Authenticator interface {
+	Authenticate(ctx context.Context, credentials Credentials) (Session, error)
+}
+
+// ErrLoginRefused is returned when the login page did not accept the credentials
+var ErrLoginRefused = errors.New("the login page refused the credentials")
+
+// LoginPage is where and how the browser logs in, the selectors follow the markup of old.reddit.com
+type LoginPage struct {
+	LoginURL string // Page with the login form
+	HomeURL  string // Where a successful login lands
+	PlaceURL string // Page sending the access token through its websocket
+
+	UserSelector     string
+	PasswordSelector string
+	RememberSelector string // Optional
+	SubmitSelector   string
+}
+
+var DefaultLoginPage = &LoginPage{
+	LoginURL:         "https://old.reddit.com/login",
+	HomeURL:          "https://old.reddit.com/",
+	PlaceURL:         "https://www.reddit.com/r/place/",
+	UserSelector:     "#user_login",
+	PasswordSelector: "#passwd_login",
+	RememberSelector: "#rem_login",
+	SubmitSelector:   "#login-form > div.c-clearfix.c-submit-group > button",
+}
+
+// RodAuthenticator logs in through the headless browser, the browser serves a single account at a time and the
+// others wait for it
+type RodAuthenticator struct {
+	Browser      *Browser
+	Page         *LoginPage    // DefaultLoginPage when nil
+	Timeout      time.Duration // For the whole login, a minute when zero
+	TokenTimeout time.Duration // How long r/place has to send the access token, 30 seconds when zero
+	Headers      bool          // Also take the access token from the Authorization header of the requests of r/place
+}
+
+func (a *RodAuthenticator) Authenticate(ctx context.Context, credentials Credentials) (session Session, err error) {
+	if a.Browser == nil {
+		return Session{}, errors.New("there is no browser to log in with")
+	}
+
+	page := a.Page
+	if page == nil {
+		page = DefaultLoginPage
+	}
+
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+
+	defer func() { // rod still panics in a few places, like a browser that went away, it must run after Free
+		if p := recover(); p != nil {
+			err = fmt.Errorf("browser: %v", p)
+		}
+	}()
+
+	if err = a.Browser.Request(ctx); err != nil { // The other accounts may hold it for a while, that's not part of the timeout
+		return Session{}, err
+	}
+	defer a.Browser.Free() // When the browser can't be started again, the next Request tries again and returns the error
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	browser := a.Browser.Browser.Context(ctx)
+
+	session.Cookies = credentials.Cookies
+	if session.Cookies == nil {
+		if session.Cookies, err = login(browser, page, credentials); err != nil {
+			return Session{}, err
+		}
+	} else if err = restore(browser, page, credentials.Cookies); err != nil {
+		return Session{}, err
+	}
+
+	tokenTimeout := a.TokenTimeout
+	if tokenTimeout == 0 {
+		tokenTimeout = 30 * time.Second
+	}
+
+	if session.Token, err = captureToken(browser, page.PlaceURL, tokenTimeout, a.Headers); err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// login fills the login form, and returns the cookies of the session
+func login(browser *rod.Browser, page *LoginPage, credentials Credentials) ([]*proto.NetworkCookie, error) {
+	p, err := browser.Page(proto.TargetCreateTarget{URL: page.LoginURL})
+	if err != nil {
+		return nil, fmt.Errorf("opening the login page: %w", err)
+	}
+	defer p.Close()
+
+	fields := []struct{ selector, text string }{
+		{page.UserSelector, credentials.Username},
+		{page.PasswordSelector, credentials.Password},
+	}
+	for _, field := range fields {
+		el, err := p.Element(field.selector)
+		if err != nil {
+			return nil, fmt.Errorf("finding %s: %w", field.selector, err)
+		}
+		if err = el.Input(field.text); err != nil {
+			return nil, fmt.Errorf("filling %s: %w", field.selector, err)
+		}
+	}
+
+	clicks := []string{page.RememberSelector, page.SubmitSelector}
+	for _, selector := range clicks {
+		if selector == "" {
+			continue
+		}
+
+		el, err := p.Element(selector)
+		if err != nil {
+			return nil, fmt.Errorf("finding %s: %w", selector, err)
+		}
+		if err = el.Click(proto.InputMouseButtonLeft, 1); err != nil {
+			return nil, fmt.Errorf("clicking %s: %w", selector, err)
+		}
+	}
+
+	if err = p.WaitStable(time.Second); err != nil {
+		return nil, fmt.Errorf("waiting for the login: %w", err)
+	}
+
+	info, err := p.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(info.URL, "/") != strings.TrimSuffix(page.HomeURL, "/") {
+		return nil, ErrLoginRefused
+	}
+
+	return p.Cookies(nil)
+}
+
+// restore loads the cookies of a previous session in the browser
+func restore(browser *rod.Browser, page *LoginPage, cookies []*proto.NetworkCookie) error {
+	p, err := browser.Page(proto.TargetCreateTarget{URL: page.HomeURL})
+	if err != nil {
+		return fmt.Errorf("opening the home page: %w", err)
+	}
+	defer p.Close()
+
+	if err = p.SetCookies(toParam(cookies)); err != nil {
+		return fmt.Errorf("restoring the cookies: %w", err)
+	}
+
+	if err = p.Reload(); err != nil {
+		return err
+	}
+
+	return p.WaitStable(time.Second)
+}
+
+// ErrNoToken is returned when r/place did not send an access token in time
+var ErrNoToken = errors.New("r/place did not send an access token")
+
+// headerGrace is how long the connection_init frame is still awaited once a request header gave a token
+const headerGrace = 5 * time.Second
+
+// captureToken opens r/place and waits for the connection_init frame of its websocket to carry the access token,
+// the Authorization header of its requests is used when no frame comes
+func captureToken(browser *rod.Browser, url string, timeout time.Duration, headers bool) (string, error) {
+	p, err := browser.Page(proto.TargetCreateTarget{})
+	if err != nil {
+		return "", err
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(p.GetContext(), timeout)
+	defer cancel()
+	p = p.Context(ctx)
+
+	frames, requests := make(chan string, 1), make(chan string, 1)
+	wait := p.EachEvent(func(e *proto.NetworkWebSocketFrameSent) bool {
+		if token := connectionInitToken(e.Response.PayloadData); token != "" {
+			frames <- token
+			return true
+		}
+		return false
+	}, func(e *proto.NetworkRequestWillBeSent) {
+		if token := headerToken(e.Request.Headers); headers && token != "" {
+			select {
+			case requests <- token:
+			default:
+			}
+		}
+	})
+	go wait()
+
+	if err = p.Navigate(url); err != nil {
+		return "", fmt.Errorf("opening r/place: %w", err)
+	}
+
+	var fallback string
+	var grace <-chan time.Time
+	for {
+		select {
+		case token := <-frames:
+			return token, nil
+		case token := <-requests:
+			if fallback == "" {
+				fallback, grace = token, time.After(headerGrace)
+			}
+		case <-grace:
+			return fallback, nil
+		case <-ctx.Done():
+			if fallback != "" {
+				return fallback, nil
+			}
+			return "", fmt.Errorf("%w within %s", ErrNoToken, timeout)
+		}
+	}
+}
+
+// connectionInitToken returns the access token of a connection_init frame, empty for any other frame
+func connectionInitToken(frame string) string {
+	var init web.ConnectionInit
+	if err := json.Unmarshal([]byte(frame), &init); err != nil || init.Type != "connection_init" {
+		return ""
+	}
+
+	return bearer(init.Payload.Authorization)
+}
+
+// headerToken returns the bearer token of the Authorization header of a request, empty if it has none
+func headerToken(headers proto.NetworkHeaders) string {
+	for name, value := range headers {
+		if strings.EqualFold(name, "Authorization") {
+			return bearer(value.Str())
+		}
+	}
+
+	return ""
+}
+
+// bearer returns the token if it's a bearer token with a value, empty otherwise
+func bearer(token string) string {
+	token = strings.TrimSpace(token)
+	if !strings.HasPrefix(token, "Bearer ") || strings.TrimSpace(strings.TrimPrefix(token, "Bearer ")) == "" {
+		return ""
+	}
+
+	return token
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/ysmood/gson"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnectionInitToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  string
+	}{
+		{name: "connection_init", frame: `{"type":"connection_init","payload":{"Authorization":"Bearer abc"}}`, want: "Bearer abc"},
+		{name: "other frame", frame: `{"type":"start","payload":{"Authorization":"Bearer abc"}}`, want: ""},
+		{name: "not a bearer token", frame: `{"type":"connection_init","payload":{"Authorization":"abc"}}`, want: ""},
+		{name: "empty bearer token", frame: `{"type":"connection_init","payload":{"Authorization":"Bearer "}}`, want: ""},
+		{name: "not JSON", frame: `ka`, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectionInitToken(tt.frame); got != tt.want {
+				t.Errorf("connectionInitToken = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderToken(t *testing.T) {
+	headers := proto.NetworkHeaders{"authorization": gson.New("Bearer abc"), "Accept": gson.New("*/*")}
+	if got := headerToken(headers); got != "Bearer abc" {
+		t.Errorf("headerToken = %q, want %q", got, "Bearer abc")
+	}
+
+	if got := headerToken(proto.NetworkHeaders{"Accept": gson.New("*/*")}); got != "" {
+		t.Errorf("headerToken without an Authorization header = %q, want none", got)
+	}
+}
+
+// testBrowser launches the browser installed on the machine, the test is skipped when there is none
+func testBrowser(t *testing.T) *Browser {
+	t.Helper()
+
+	if _, ok := launcher.LookPath(); !ok {
+		t.Skip("no browser installed")
+	}
+
+	browser, err := NewBrowser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { browser.Close() })
+
+	return browser
+}
+
+func TestRodAuthenticatorFixture(t *testing.T) {
+	browser := testBrowser(t)
+
+	server := httptest.NewServer(newFixture(map[string]string{"alice": "hunter2"}, nil))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		password  string
+		wantToken string
+		wantErr   error
+	}{
+		{name: "logged in", password: "hunter2", wantToken: "Bearer fixture-alice"},
+		{name: "refused", password: "wrong", wantErr: ErrLoginRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := &RodAuthenticator{Browser: browser, Page: fixtureLoginPage(server.URL), Timeout: 30 * time.Second, TokenTimeout: 10 * time.Second}
+
+			session, err := authenticator.Authenticate(context.Background(), Credentials{Username: "alice", Password: tt.password})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if session.Token != tt.wantToken {
+				t.Errorf("token = %q, want %q", session.Token, tt.wantToken)
+			}
+			if tt.wantErr == nil && !hasSession(session.Cookies, "alice") {
+				t.Errorf("the session cookie is missing from %v", session.Cookies)
+			}
+		})
+	}
+}
+
+func TestCaptureTokenTimeout(t *testing.T) {
+	browser := testBrowser(t)
+
+	server := httptest.NewServer(newFixture(nil, nil))
+	defer server.Close()
+
+	// The home page never opens the websocket, so no token comes
+	_, err := captureToken(browser.Browser, server.URL+"/", time.Second, true)
+	if !errors.Is(err, ErrNoToken) {
+		t.Errorf("err = %v, want %v", err, ErrNoToken)
+	}
+}
@@ -1,52 +1,81 @@
 package client
 
 import (
+	"context"
+	"fmt"
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
-	"sync"
 )
 
+// Browser is shared by the accounts, rod doesn't support multithreading so only one of them uses it at a time
 type Browser struct {
-	mu     sync.Mutex
-	free   bool // We use a free variable to determine if the client can have access to the browser since rod doesn't support multithreading
-	caller *Client
+	access chan struct{} // Holds a value while a client uses the browser
 	*rod.Browser
 }
 
-func NewBrowser() *Browser {
-	return &Browser{free: true, Browser: rod.New().ControlURL(launcher.New().Leakless(false).MustLaunch()).MustConnect()}
-}
+// NewBrowser launches the headless browser the accounts log in with
+func NewBrowser() (*Browser, error) {
+	br := &Browser{access: make(chan struct{}, 1)}
+	if err := br.connect(); err != nil {
+		return nil, err
+	}
 
-func (br *Browser) CanAccess() bool {
-	return br.waitForAccess() // block the thread until the browser is free
+	return br, nil
 }
 
-func (br *Browser) waitForAccess() bool {
-	for !br.free {
+// connect launches a new browser, the Browser is left nil when it fails so the next request tries again
+func (br *Browser) connect() error {
+	br.Browser = nil
+
+	url, err := launcher.New().Leakless(false).Launch()
+	if err != nil {
+		return fmt.Errorf("launching the browser: %w", err)
 	}
 
-	return true
+	browser := rod.New().ControlURL(url)
+	if err = browser.Connect(); err != nil {
+		return fmt.Errorf("connecting to the browser: %w", err)
+	}
+
+	br.Browser = browser
+	return nil
 }
 
-// Request the browser for the client to use.
-func (br *Browser) Request(call *Client) {
-	br.mu.Lock()
-	defer br.mu.Unlock()
+// Request waits until the browser is free and keeps it for the caller, it fails when the context ends first or when
+// the browser could not be started again
+func (br *Browser) Request(ctx context.Context) error {
+	select {
+	case br.access <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	br.CanAccess()
-	br.caller = call
-	br.free = false
+	if br.Browser == nil {
+		if err := br.connect(); err != nil {
+			<-br.access
+			return err
+		}
+	}
+
+	return nil
 }
 
-// Free the browser for other clients to use.
-// MUST BE CALLED AFTER EVERY CLIENT ACTION
-func (br *Browser) Free() {
-	br.free = false
-	br.new()
+// Free the browser for other clients to use, the browser is started again so the next client gets a clean one.
+// MUST BE CALLED AFTER EVERY SUCCESSFUL REQUEST
+func (br *Browser) Free() error {
+	defer func() { <-br.access }()
+
+	if err := br.Close(); err != nil {
+		br.Browser = nil // The next request starts a new one
+		return err
+	}
+	return br.connect()
 }
 
-func (br *Browser) new() {
-	br.Browser.Close()
-	br.Browser = rod.New().MustConnect()
-	br.free = true
+// Close closes the browser, it does nothing when it could not be started
+func (br *Browser) Close() error {
+	if br.Browser == nil {
+		return nil
+	}
+	return br.Browser.Close()
 }
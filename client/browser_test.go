@@ -0,0 +1,20 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBrowserRequestWaits(t *testing.T) {
+	br := &Browser{access: make(chan struct{}, 1)}
+	br.access <- struct{}{} // Another account is logging in
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := br.Request(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v while the browser is used", err, context.DeadlineExceeded)
+	}
+}
@@ -9,219 +9,221 @@ import (
 	"github.com/Edouard127/redditplacebot/board"
 	"github.com/Edouard127/redditplacebot/util"
 	"github.com/Edouard127/redditplacebot/web"
-	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/proto"
 	"go.uber.org/zap"
 	"golang.org/x/net/proxy"
-	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
-	"nhooyr.io/websocket/wsjson"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"nhooyr.io/websocket"
 )
 
+// ErrNotSetup is returned by the requests of a client that Setup was not called on
+var ErrNotSetup = errors.New("the client is not set up")
+
 type Client struct {
 	*zap.Logger `json:"-"`
-	Username    string `json:"username"`
-	Password    string `json:"password"`
-	AccessToken string `json:"access_token"`
-
-	HTTP         *http.Client                                             `json:"-"`
-	Browser      *Browser                                                 `json:"-"`
-	WSconfig     *websocket.DialOptions                                   `json:"-"`
-	Socket       *websocket.Conn                                          `json:"-"`
-	Page         *rod.Page                                                `json:"-"`
-	Cookies      []*proto.NetworkCookie                                   `json:"cookies"`
-	AssignedData *util.CircularQueue[util.Pair[board.Point, board.Color]] `json:"-"`
-
-	packetid int
+	Username    string    `json:"username"`
+	Password    string    `json:"password"`
+	AccessToken string    `json:"access_token"`
+	TokenIssued time.Time `json:"token_issued"` // When the browser got the token, its expiry is guessed from it when the token doesn't have one
+	Status      Status    `json:"status"`
+
+	Board    *board.Board           `json:"-"`
+	Browser  *Browser               `json:"-"`
+	WSconfig *websocket.DialOptions `json:"-"`
+	Cookies  []*proto.NetworkCookie `json:"cookies"`
+	Clock    util.Clock             `json:"-"`
+	Rand     *util.Rand             `json:"-"`
+	// Endpoints of the server, DefaultEndpoints when nil
+	Endpoints *Endpoints `json:"-"`
+	// Authenticator logs the account in, through the browser when nil
+	Authenticator Authenticator `json:"-"`
+
+	tokenLock   sync.RWMutex // The token is refreshed while the client places
+	statusLock  sync.Mutex
+	connLock    sync.Mutex      // The HTTP client is replaced every minute and the websocket on every reconnection
+	http        *http.Client    // Sends the GraphQL requests, through a new Tor circuit every minute
+	socket      *websocket.Conn // Follows the canvas, nil when the client is not connected
+	stop        chan struct{}   // Stops replacing the HTTP client, nil when it's not replaced
+	lastMessage atomic.Int64    // Unix nanoseconds of the last websocket message
 }
 
-func (cl *Client) Login(wg *sync.WaitGroup) error {
-	defer wg.Done()
-	defer cl.Setup()
-
-	if cl.AccessToken != "" {
-		go cl.connect()
-		cl.Info("Login successful")
-		return nil
+// Login authenticates the client and starts following the canvas
+func (cl *Client) Login() error {
+	if err := cl.Authenticate(); err != nil {
+		return err
 	}
 
-	cl.Browser.Request(cl)
-	defer cl.Browser.Free()
-
-	if cl.Cookies == nil {
-		cl.Page = cl.Browser.MustPage("https://old.reddit.com/login")
-
-		cl.Page.MustElement("#user_login").MustInput(cl.Username)
-		cl.Page.MustElement("#passwd_login").MustInput(cl.Password)
-		cl.Page.MustElement("#rem_login").MustClick()
-
-		cl.Page.MustElement("#login-form > div.c-clearfix.c-submit-group > button").MustClick()
-		cl.Page.MustWaitStable()
-
-		// Get current url
-		if cl.Page.MustInfo().URL != "https://old.reddit.com/" {
-			cl.Error("Login failed", zap.String("username", cl.Username))
-			return fmt.Errorf("login failed")
-		}
-
-		cl.Cookies = cl.Page.MustCookies()
-	} else {
-		cl.Page = cl.Browser.MustPage("https://old.reddit.com/")
-		cl.Page.MustSetCookies(toParam(cl.Cookies)...)
-		cl.Page.MustReload()
-		cl.Page.MustWaitStable()
-	}
-
-	cl.getAccessToken()
-	go cl.connect(board)
+	cl.Setup()
+	go cl.connect()
 
 	cl.Info("Login successful")
 	return nil
 }
 
-func (cl *Client) getAccessToken() {
-	var connInit web.ConnectionInit
+// Authenticate gets an access token through the browser, unless the client already has one that did not expire
+func (cl *Client) Authenticate() error {
+	return cl.authenticate(false)
+}
+
+func (cl *Client) authenticate(force bool) error {
+	if !force && !cl.NeedsRefresh(cl.clock().Now(), 0) {
+		return nil
+	}
 
-	cl.Page = cl.Browser.MustPage("https://www.reddit.com/r/place/")
+	if !cl.Expiry().IsZero() {
+		cl.Info("Refreshing the access token", zap.Time("expiry", cl.Expiry()))
+	}
 
-	wait := cl.Page.EachEvent(func(e *proto.NetworkWebSocketFrameSent) bool {
-		json.Unmarshal([]byte(e.Response.PayloadData), &connInit)
-		return true
+	session, err := cl.authenticator().Authenticate(context.Background(), Credentials{
+		Username: cl.Username,
+		Password: cl.Password,
+		Cookies:  cl.cookies(),
 	})
+	if err != nil {
+		return err
+	}
 
-	wait()
+	cl.setSession(session.Cookies, session.Token, cl.clock().Now())
+	return nil
+}
 
-	cl.AccessToken = connInit.Payload.Authorization
+func (cl *Client) authenticator() Authenticator {
+	if cl.Authenticator == nil {
+		return &RodAuthenticator{Browser: cl.Browser, Headers: true}
+	}
+	return cl.Authenticator
 }
 
 func (cl *Client) connect() {
-	var err error
-	cl.Socket, _, err = websocket.Dial(context.Background(), "wss://gql-realtime-2.reddit.com/query", cl.WSconfig)
+	conn, _, err := websocket.Dial(context.Background(), cl.endpoints().Socket, cl.WSconfig)
 	if err != nil {
 		cl.Error("Failed to connect to websocket", zap.Error(err))
 		return
 	}
-	defer cl.Socket.Close(websocket.StatusNormalClosure, "user closed connection")
+	defer conn.Close(websocket.StatusNormalClosure, "user closed connection")
+
+	cl.connLock.Lock()
+	cl.socket = conn
+	cl.connLock.Unlock()
+
+	sub := &subscription{
+		Logger:     cl.Logger,
+		board:      cl.Board,
+		controller: cl,
+		token:      cl.token(),
+		teamOwner:  cl.endpoints().TeamOwner,
+		seen:       func() { cl.lastMessage.Store(cl.clock().Now().UnixNano()) },
+	}
 
-	login := web.ConnectionInit{
-		Type: "connection_init",
-		Payload: web.Authorization{
-			Authorization: cl.AccessToken,
-		},
+	if err = sub.follow(conn); err != nil {
+		cl.Error("Stopped following the canvas", zap.Error(err))
 	}
+}
 
-	cl.packetid++
+// Healthy reports if the websocket of the client received a message during the last timeout
+func (cl *Client) Healthy(now time.Time, timeout time.Duration) bool {
+	last := cl.lastMessage.Load()
+	return last != 0 && now.Sub(time.Unix(0, last)) < timeout
+}
 
-	subscribe := web.Subscribe{
-		Id:   fmt.Sprintf("%d", cl.packetid),
-		Type: "start",
-		Payload: web.Var[web.VarInput[web.Input[web.SubscribeConfig]]]{
-			Variables: web.VarInput[web.Input[web.SubscribeConfig]]{
-				Input: web.Input[web.SubscribeConfig]{
-					Channel: web.SubscribeConfig{
-						TeamOwner: "GARLICBREAD",
-						Category:  "CONFIG",
-					},
-				},
-			},
-			OperationName: "configuration",
-			Query:         "subscription configuration($input: SubscribeInput!) {\n  subscribe(input: $input) {\n    id\n    ... on BasicMessage {\n      data {\n        __typename\n        ... on ConfigurationMessageData {\n          colorPalette {\n            colors {\n              hex\n              index\n              __typename\n            }\n            __typename\n          }\n          canvasConfigurations {\n            index\n            dx\n            dy\n            __typename\n          }\n          activeZone {\n            topLeft {\n              x\n              y\n              __typename\n            }\n            bottomRight {\n              x\n              y\n              __typename\n            }\n            __typename\n          }\n          canvasWidth\n          canvasHeight\n          adminConfiguration {\n            maxAllowedCircles\n            maxUsersPerAdminBan\n            __typename\n          }\n          __typename\n        }\n      }\n      __typename\n    }\n    __typename\n  }\n}\n",
-		},
+// Reconnect drops the websocket and subscribes again, the board gets full frames from the new subscription
+func (cl *Client) Reconnect() {
+	reconnects.Inc("client")
+	cl.disconnect()
+	go cl.connect()
+}
+
+// Close disconnects the client from the websocket, it can't feed the board anymore, and stops replacing its HTTP client
+func (cl *Client) Close() {
+	cl.disconnect()
+
+	cl.connLock.Lock()
+	defer cl.connLock.Unlock()
+
+	if cl.stop != nil {
+		close(cl.stop)
+		cl.stop = nil
 	}
+}
 
-	getCanvas := func(tag string) web.Replace {
-		cl.packetid++
-		return web.Replace{
-			Id:   fmt.Sprintf("%d", cl.packetid),
-			Type: "start",
-			Payload: web.Var[web.VarInput[web.Input[web.SubscribeReplace]]]{
-				Variables: web.VarInput[web.Input[web.SubscribeReplace]]{
-					Input: web.Input[web.SubscribeReplace]{
-						Channel: web.SubscribeReplace{
-							TeamOwner: "GARLICBREAD",
-							Category:  "CANVAS",
-							Tag:       tag,
-						},
-					},
-				},
-				OperationName: "replace",
-				Query:         "subscription replace($input: SubscribeInput!) {\n  subscribe(input: $input) {\n    id\n    ... on BasicMessage {\n      data {\n        __typename\n        ... on FullFrameMessageData {\n          __typename\n          name\n          timestamp\n        }\n        ... on DiffFrameMessageData {\n          __typename\n          name\n          currentTimestamp\n          previousTimestamp\n        }\n      }\n      __typename\n    }\n    __typename\n  }\n}\n",
-			},
-		}
+func (cl *Client) disconnect() {
+	if socket := cl.websocket(); socket != nil {
+		socket.Close(websocket.StatusNormalClosure, "client left")
 	}
+}
 
-	err = wsjson.Write(context.Background(), cl.Socket, login)
+func (cl *Client) websocket() *websocket.Conn {
+	cl.connLock.Lock()
+	defer cl.connLock.Unlock()
 
-	var errorPayload web.ConnectionUnauthorized
-	for {
-		err = wsjson.Read(context.Background(), cl.Socket, &errorPayload)
-		if err != nil {
-			fmt.Println("Error receiving message from socket", err)
-			return
-		}
+	return cl.socket
+}
 
-		if errorPayload.Type != "connection_error" {
-			break
-		} else {
-			cl.Error("Connection error", zap.String("username", cl.Username), zap.String("message", errorPayload.Payload.Message))
-		}
-	}
+func (cl *Client) httpClient() *http.Client {
+	cl.connLock.Lock()
+	defer cl.connLock.Unlock()
 
-	err = wsjson.Write(context.Background(), cl.Socket, subscribe)
+	return cl.http
+}
 
-	var data web.SubscribedData
-	for {
-		err = wsjson.Read(context.Background(), cl.Socket, &data)
-		if err != nil {
-			fmt.Println("Error receiving message from socket", err)
-			return
-		}
+// Setup creates the HTTP client of the client, and recreates it every minute until Close so the requests go through a
+// new Tor circuit
+func (cl *Client) Setup() {
+	cl.setupHTTP()
 
-		if data.Type == "data" {
-			break
-		}
+	cl.connLock.Lock()
+	defer cl.connLock.Unlock()
+
+	if cl.stop != nil {
+		return
 	}
 
-	board.SetController(cl) // Do not remove
-	board.SetColors(cl, data.Payload.Data.Subscribe.Data.ColorPalette.Colors)
+	stop := make(chan struct{})
+	cl.stop = stop
 
-	err = wsjson.Write(context.Background(), cl.Socket, getCanvas("0"))
-	err = wsjson.Write(context.Background(), cl.Socket, getCanvas("1"))
-	err = wsjson.Write(context.Background(), cl.Socket, getCanvas("2"))
-	err = wsjson.Write(context.Background(), cl.Socket, getCanvas("3"))
-	err = wsjson.Write(context.Background(), cl.Socket, getCanvas("4"))
-	err = wsjson.Write(context.Background(), cl.Socket, getCanvas("5"))
+	go func() {
+		ticker := cl.clock().NewTicker(time.Minute)
+		defer ticker.Stop()
 
-	var canvasData web.CanvasUpdate
-	for {
-		err = wsjson.Read(context.Background(), cl.Socket, &canvasData)
-		if err != nil {
-			fmt.Println("Error receiving message from socket", err)
-			return
-		}
+		for {
+			select {
+			case <-ticker.C():
+			case <-stop:
+				return
+			}
 
-		board.SetCurrentData(cl, canvasData.Payload.Data.Subscribe.Data.Name)
-	}
+			select {
+			case <-stop: // A tick may be pending when the client is closed
+				return
+			default:
+				cl.setupHTTP()
+			}
+		}
+	}()
 }
 
-func (cl *Client) Setup() {
-	dialer, err := proxy.SOCKS5("tcp", "127.0.0.1:9050", nil, proxy.Direct)
-	if err != nil {
-		panic(err)
+func (cl *Client) setupHTTP() {
+	var dialer proxy.Dialer = proxy.Direct
+	if address := cl.endpoints().Proxy; address != "" {
+		var err error
+		if dialer, err = proxy.SOCKS5("tcp", address, nil, proxy.Direct); err != nil {
+			panic(err)
+		}
 	}
 
 	jar, _ := cookiejar.New(nil)
 
-	cl.HTTP = &http.Client{
+	client := &http.Client{
 		Transport: &http.Transport{
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 				return dialer.Dial(network, addr)
@@ -230,44 +232,42 @@ func (cl *Client) Setup() {
 		Jar: jar,
 	}
 
-	cookies := make([]*http.Cookie, len(cl.Cookies))
-	for i, cookie := range cl.Cookies {
+	session := cl.cookies()
+	cookies := make([]*http.Cookie, len(session))
+	for i, cookie := range session {
 		cookies[i] = &http.Cookie{
 			Name:  cookie.Name,
 			Value: cookie.Value,
 		}
 	}
 
-	cl.HTTP.Jar.SetCookies(&url.URL{
+	client.Jar.SetCookies(&url.URL{
 		Scheme: "https",
 		Host:   ".reddit.com",
 		Path:   "/",
 	}, cookies)
 
-	go func() {
-		for {
-			select {
-			case <-time.NewTicker(time.Second * 60).C:
-				cl.Setup()
-			default:
-
-			}
-		}
-	}()
+	cl.connLock.Lock()
+	cl.http = client
+	cl.connLock.Unlock()
 }
 
-func (cl *Client) Assign(data map[board.Point]board.Color) {
-	for point, color := range data {
-		cl.AssignedData.Enqueue(util.Pair[board.Point, board.Color]{First: point, Second: color})
+// Place places a pixel at the given point, does not require a browser allocation
+func (cl *Client) Place(b *board.Board, at board.Point, color board.Color) PlaceResult {
+	defer observeRequest("place", time.Now())
+	var result PlaceResult
+	if canvas, ok := b.GetCanvasIndex(at); ok {
+		result = cl.place(at, color, canvas)
+	} else {
+		result = PlaceResult{Kind: Protocol, Err: fmt.Errorf("%w: %v", board.ErrOffCanvas, at)}
 	}
+	result.Point, result.Color = at, color
+	placements.Inc(result.Outcome())
+	return result
 }
 
-// Place places a pixel at the given point, does not require a browser allocation
-// Fix: It doesn't return any errors but doesn't place a pixel either
-func (cl *Client) Place(color, canvas int) time.Time {
-	data := cl.AssignedData.Dequeue()
-
-	place, _ := json.Marshal(web.Place{
+func (cl *Client) place(at board.Point, color board.Color, canvas int) PlaceResult {
+	result := cl.act(web.Place{
 		OperationName: "setPixel",
 		Query:         "mutation setPixel($input: ActInput!) {\n  act(input: $input) {\n    data {\n      ... on BasicMessage {\n        id\n        data {\n          ... on GetUserCooldownResponseMessageData {\n            nextAvailablePixelTimestamp\n            __typename\n          }\n          ... on SetPixelResponseMessageData {\n            timestamp\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      __typename\n    }\n    __typename\n  }\n}\n",
 		Variables: web.PlacePixel{
@@ -275,70 +275,121 @@ func (cl *Client) Place(color, canvas int) time.Time {
 				ActionName: "r/replace:set_pixel",
 				PixelMessageData: web.PlaceData{
 					CanvasIndex: canvas,
-					ColorIndex:  color,
-					Coordinate:  data.First.ToPlacePoint(canvas),
+					ColorIndex:  board.GetColorIndex(color),
+					Coordinate:  web.Point(at.ToPlacePoint(canvas)),
 				},
 			},
 		},
 	})
+	if result.Kind != NoError {
+		return result
+	}
 
-	req, err := http.NewRequest("POST", "https://gql-realtime-2.reddit.com/query", bytes.NewReader(place))
-	if err != nil {
-		cl.Error("Error creating request", zap.Error(err))
-		if errors.Is(err, os.ErrDeadlineExceeded) {
-			cl.Setup()
-			return cl.Place(color, canvas)
-		}
-		return time.Now()
+	result.Placed = true
+	if result.PlacedAt.IsZero() {
+		result.PlacedAt = cl.clock().Now()
 	}
+	if result.NextAvailable.IsZero() {
+		result.NextAvailable = cl.clock().Now().Add(5 * time.Minute)
+	}
+	result.NextAvailable = result.NextAvailable.Add(cl.jitter())
 
-	req.Header.Set("Authorization", cl.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Origin", "https://hot-potato.reddit.com")
-	req.Header.Set("Referer", "https://hot-potato.reddit.com/")
+	return result
+}
 
-	resp, err := cl.HTTP.Do(req)
+// GetCooldown asks the server when the client can place again, NextAvailable is the zero time if it can place now
+func (cl *Client) GetCooldown() PlaceResult {
+	defer observeRequest("cooldown", time.Now())
+	return cl.act(web.UserCooldown{
+		OperationName: "getUserCooldown",
+		Query:         "mutation getUserCooldown($input: ActInput!) {\n  act(input: $input) {\n    data {\n      ... on BasicMessage {\n        id\n        data {\n          ... on GetUserCooldownResponseMessageData {\n            nextAvailablePixelTimestamp\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      __typename\n    }\n    __typename\n  }\n}\n",
+		Variables: web.VarInput[web.ActionInput]{
+			Input: web.ActionInput{
+				ActionName: "r/replace:get_user_cooldown",
+			},
+		},
+	})
+}
+
+// act sends an act mutation and reads the cooldown the server answers with
+func (cl *Client) act(payload any) PlaceResult {
+	resp, err := cl.post(payload)
 	if err != nil {
-		cl.Error("Error sending request", zap.Error(err))
-		return time.Now()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			cl.setupHTTP()
+		}
+		return PlaceResult{Kind: Transport, Err: fmt.Errorf("send request: %w", err)}
 	}
 
 	defer resp.Body.Close()
 
-	var response web.Error
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return PlaceResult{Kind: AuthExpired, Err: fmt.Errorf("server answered %s", resp.Status)}
+	}
+
+	var response web.PlaceResponse
 
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		cl.Error("Error decoding response", zap.Error(err))
-		return time.Now()
+		return PlaceResult{Kind: Protocol, Err: fmt.Errorf("decode response: %w", err)}
 	}
 
-	if len(response.Errors) == 0 {
-		if cl.GetPlaceHistory(data.First, canvas).Data.Act.Data[0].Data.UserInfo.Username != cl.Username {
-			cl.Info("There was an error placing pixel", zap.String("message", "Pixel was not placed, or was placed somewhere else"))
+	if len(response.Errors) > 0 {
+		result := placeError(response.Errors[0])
+		if result.Kind == RateLimited {
+			result.NextAvailable = result.NextAvailable.Add(cl.jitter())
 		}
-		return time.Now().Add(5 * time.Minute).Add(time.Duration(rand.Intn(60)) * time.Second)
-	} else {
-		cl.Info("Error placing pixel", zap.String("message", response.Errors[0].Message))
+		return result
+	}
 
-		if response.Errors[0].Message == "Ratelimited" {
-			if int64(response.Errors[0].Extensions.NextAvailablePixelTimestamp.(float64))/1000 == math.MaxInt32 {
-				cl.Info("Account has been banned from r/place")
-			}
+	var result PlaceResult
+	for _, d := range response.Data.Act.Data {
+		if next := d.Data.NextAvailablePixelTimestamp; next.Banned() {
+			return PlaceResult{Kind: Banned, Err: errors.New("banned cooldown")}
+		} else if !next.Time().IsZero() {
+			result.NextAvailable = next.Time()
+		}
+
+		if d.Data.Timestamp != 0 {
+			result.PlacedAt = time.UnixMilli(int64(d.Data.Timestamp))
+		}
+	}
+
+	return result
+}
+
+func placeError(e web.ErrorData) PlaceResult {
+	err := errors.New(e.Message)
 
-			return time.Unix(int64(response.Errors[0].Extensions.NextAvailablePixelTimestamp.(float64))/1000, int64(response.Errors[0].Extensions.NextAvailablePixelTimestamp.(float64))).Add(time.Duration(rand.Intn(60)) * time.Second)
+	switch strings.ToLower(e.Message) {
+	case "ratelimited":
+		next := e.Extensions.NextAvailablePixelTimestamp
+		if next == 0 {
+			return PlaceResult{Kind: Protocol, Err: fmt.Errorf("rate limited without a cooldown")}
 		}
 
-		if response.Errors[0].Message == "unable to verify user" {
-			cl.Info("Account does not have access to r/place due to not being email verified")
+		if next.Banned() {
+			return PlaceResult{Kind: Banned, Err: err}
 		}
 
-		return time.Now().Add(math.MaxInt32 * time.Second)
+		return PlaceResult{
+			Kind:          RateLimited,
+			NextAvailable: next.Time(),
+			Err:           err,
+		}
+	case "unable to verify user":
+		return PlaceResult{Kind: Unverified, Err: err}
+	case "unauthorized", "unauthenticated", "invalid token":
+		return PlaceResult{Kind: AuthExpired, Err: err}
 	}
+
+	return PlaceResult{Kind: Protocol, Err: err}
 }
 
-func (cl *Client) GetPlaceHistory(at board.Point, canvas int) web.HistoryResponse {
-	history, _ := json.Marshal(web.History{
+// GetPlaceHistory returns who last modified the pixel at the given point, and when
+func (cl *Client) GetPlaceHistory(at board.Point, canvas int) (web.LastModified, error) {
+	defer observeRequest("history", time.Now())
+	resp, err := cl.post(web.History{
 		OperationName: "pixelHistory",
 		Query:         "mutation pixelHistory($input: ActInput!) {\n  act(input: $input) {\n    data {\n      ... on BasicMessage {\n        id\n        data {\n          ... on GetTileHistoryResponseMessageData {\n            lastModifiedTimestamp\n            userInfo {\n              userID\n              username\n              __typename\n            }\n            __typename\n          }\n          __typename\n        }\n        __typename\n      }\n      __typename\n    }\n    __typename\n  }\n}\n",
 		Variables: web.VarInput[web.PlaceInput[web.PlaceData]]{
@@ -346,37 +397,82 @@ func (cl *Client) GetPlaceHistory(at board.Point, canvas int) web.HistoryRespons
 				ActionName: "r/replace:get_tile_history",
 				PixelMessageData: web.PlaceData{
 					CanvasIndex: canvas,
-					Coordinate:  at.ToPlacePoint(canvas),
+					Coordinate:  web.Point(at.ToPlacePoint(canvas)),
 				},
 			},
 		},
 	})
-
-	req, err := http.NewRequest("POST", "https://gql-realtime-2.reddit.com/query", bytes.NewReader(history))
 	if err != nil {
-		cl.Error("Error creating request", zap.Error(err))
+		return web.LastModified{}, err
 	}
 
-	req.Header.Set("Authorization", cl.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Origin", "https://hot-potato.reddit.com")
-	req.Header.Set("Referer", "https://hot-potato.reddit.com/")
+	defer resp.Body.Close()
 
-	resp, err := cl.HTTP.Do(req)
+	var response web.HistoryResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		cl.Error("Error sending request", zap.Error(err))
+		return web.LastModified{}, fmt.Errorf("decode response: %w", err)
 	}
 
-	defer resp.Body.Close()
+	if len(response.Errors) > 0 {
+		return web.LastModified{}, errors.New(response.Errors[0].Message)
+	}
 
-	var response web.HistoryResponse
+	if len(response.Data.Act.Data) == 0 {
+		return web.LastModified{}, fmt.Errorf("no history for %v", at)
+	}
 
-	err = json.NewDecoder(resp.Body).Decode(&response)
+	return response.Data.Act.Data[0].Data, nil
+}
+
+// post sends a query to the server with the credentials of the client
+func (cl *Client) post(payload any) (*http.Response, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		cl.Error("Error decoding response", zap.Error(err))
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", cl.endpoints().Query, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", cl.token())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", cl.endpoints().Origin)
+	req.Header.Set("Referer", cl.endpoints().Origin+"/")
+
+	client := cl.httpClient()
+	if client == nil {
+		return nil, ErrNotSetup
+	}
+
+	return client.Do(req)
+}
+
+// jitter spreads the placements of the clients, so they don't all place at the same second
+func (cl *Client) jitter() time.Duration {
+	if cl.Rand == nil {
+		return time.Duration(rand.Intn(60)) * time.Second
+	}
+
+	return time.Duration(cl.Rand.Intn(60)) * time.Second
+}
+
+func (cl *Client) endpoints() *Endpoints {
+	if cl.Endpoints == nil {
+		return DefaultEndpoints
+	}
+	return cl.Endpoints
+}
+
+func (cl *Client) clock() util.Clock {
+	if cl.Clock == nil {
+		return util.RealClock
 	}
 
-	return response
+	return cl.Clock
 }
 
 func toParam(cookies []*proto.NetworkCookie) []*proto.NetworkCookieParam {
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"errors"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestRequestWithoutSetup(t *testing.T) {
+	cl := &Client{Logger: zap.NewNop(), Endpoints: &Endpoints{Query: "http://127.0.0.1:1/query"}}
+
+	result := cl.GetCooldown()
+	if result.Kind != Transport || !errors.Is(result.Err, ErrNotSetup) {
+		t.Errorf("GetCooldown = %v, want a transport error for a client that is not set up", result)
+	}
+}
+
+func TestCloseStopsHTTPRefresh(t *testing.T) {
+	clock := util.NewFakeClock(time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC))
+	cl := &Client{Logger: zap.NewNop(), Clock: clock, Endpoints: &Endpoints{}}
+
+	cl.Setup()
+	first := cl.httpClient()
+
+	// The ticker is created by the goroutine of Setup, so the clock is moved until it replaced the HTTP client
+	for waited := 0; cl.httpClient() == first; waited++ {
+		if waited == 1000 {
+			t.Fatal("the HTTP client was never replaced")
+		}
+		clock.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+
+	cl.Close()
+	closed := cl.httpClient()
+
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if cl.httpClient() != closed {
+		t.Error("the HTTP client is still replaced after Close")
+	}
+}
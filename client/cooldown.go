@@ -0,0 +1,52 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// Cooldowns tracks when each client is allowed to place again
+type Cooldowns struct {
+	mu   sync.Mutex
+	next map[*Client]time.Time
+}
+
+func NewCooldowns() *Cooldowns {
+	return &Cooldowns{next: make(map[*Client]time.Time)}
+}
+
+// Set overrides the moment the client can place again
+func (c *Cooldowns) Set(cl *Client, next time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.next[cl] = next
+}
+
+// Observe updates the cooldown of the client from a placement result
+func (c *Cooldowns) Observe(cl *Client, result PlaceResult) {
+	if result.NextAvailable.IsZero() {
+		return
+	}
+
+	c.Set(cl, result.NextAvailable)
+}
+
+// Next returns when the client can place again, the zero time if it never placed
+func (c *Cooldowns) Next(cl *Client) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.next[cl]
+}
+
+func (c *Cooldowns) Ready(cl *Client, now time.Time) bool {
+	return !c.Next(cl).After(now)
+}
+
+func (c *Cooldowns) Forget(cl *Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.next, cl)
+}
@@ -0,0 +1,18 @@
+package client
+
+// Endpoints is where the clients talk to r/place, a mock server can stand in for it
+type Endpoints struct {
+	Socket    string // Websocket of the configuration and the canvases
+	Query     string // GraphQL endpoint of the placements and the pixel history
+	Origin    string // Origin of the GraphQL requests
+	TeamOwner string // Owner of the canvas channels
+	Proxy     string // SOCKS5 address the requests go through, empty to connect directly
+}
+
+var DefaultEndpoints = &Endpoints{
+	Socket:    "wss://gql-realtime-2.reddit.com/query",
+	Query:     "https://gql-realtime-2.reddit.com/query",
+	Origin:    "https://hot-potato.reddit.com",
+	TeamOwner: "GARLICBREAD",
+	Proxy:     "127.0.0.1:9050",
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"embed"
+	"github.com/go-rod/rod/lib/proto"
+	"html/template"
+	"net/http"
+	"nhooyr.io/websocket"
+)
+
+//go:embed testdata/*.html
+var fixtures embed.FS
+
+var placeFixture = template.Must(template.ParseFS(fixtures, "testdata/place.html"))
+
+// newFixture serves a copy of the login flow of reddit, so the RodAuthenticator can be tried offline:
+// serve it with httptest and log in with fixtureLoginPage of its URL
+func newFixture(passwords map[string]string, token func(username string) string) http.Handler {
+	if token == nil {
+		token = func(username string) string { return "Bearer fixture-" + username }
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			username := r.FormValue("user")
+			if password, ok := passwords[username]; ok && password == r.FormValue("passwd") {
+				http.SetCookie(w, &http.Cookie{Name: "reddit_session", Value: username, Path: "/"})
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+		}
+
+		serveFixture(w, "testdata/login.html")
+	})
+
+	mux.HandleFunc("/r/place/", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("reddit_session")
+		if err != nil {
+			http.Error(w, "not logged in", http.StatusUnauthorized)
+			return
+		}
+
+		placeFixture.Execute(w, token(cookie.Value))
+	})
+
+	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "")
+
+		for { // The frames are read by the browser devtools, we only keep the socket open
+			if _, _, err = conn.Read(context.Background()); err != nil {
+				return
+			}
+		}
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		serveFixture(w, "testdata/home.html")
+	})
+
+	return mux
+}
+
+func serveFixture(w http.ResponseWriter, name string) {
+	page, err := fixtures.ReadFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// fixtureLoginPage is the login page of a fixture served at the given URL
+func fixtureLoginPage(url string) *LoginPage {
+	page := *DefaultLoginPage
+	page.LoginURL = url + "/login"
+	page.HomeURL = url + "/"
+	page.PlaceURL = url + "/r/place/"
+
+	return &page
+}
+
+// hasSession reports if the cookies hold the session of the fixture for the account
+func hasSession(cookies []*proto.NetworkCookie, username string) bool {
+	for _, cookie := range cookies {
+		if cookie.Name == "reddit_session" && cookie.Value == username {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"github.com/Edouard127/redditplacebot/metrics"
+	"strings"
+	"time"
+)
+
+var (
+	placements      = metrics.NewCounter("placebot_placements_total", "Pixels sent to the server by outcome.", "outcome")
+	requestDuration = metrics.NewHistogram("placebot_request_duration_seconds", "Duration of the requests to the GraphQL endpoint.", metrics.DefaultBuckets, "request")
+	frames          = metrics.NewCounter("placebot_frames_total", "Messages of the canvas subscriptions by type.", "type")
+	reconnects      = metrics.NewCounter("placebot_reconnects_total", "Websockets opened again after they closed or went quiet.", "source")
+)
+
+// observeRequest records how long a request started at start took, it's deferred by the requests
+func observeRequest(request string, start time.Time) {
+	requestDuration.Observe(time.Since(start).Seconds(), request)
+}
+
+// Outcome names the result of a placement for the metrics, placed or the kind of error
+func (r PlaceResult) Outcome() string {
+	if r.Placed {
+		return "placed"
+	}
+	return strings.ReplaceAll(r.Kind.String(), " ", "_")
+}
+
+// frameType names the message of a canvas subscription for the metrics
+func frameType(typename string) string {
+	switch typename {
+	case "FullFrameMessageData":
+		return "full"
+	case "DiffFrameMessageData":
+		return "diff"
+	}
+	return "other"
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observer follows the canvas without placing pixels, so the board stays fresh whatever happens to the accounts
+type Observer struct {
+	*zap.Logger
+	Board    *board.Board
+	Token    string // Empty to follow the canvas without an account, if the server allows it
+	WSconfig *websocket.DialOptions
+	Clock    util.Clock
+	// Endpoints of the server, DefaultEndpoints when nil
+	Endpoints *Endpoints
+
+	mu          sync.Mutex
+	socket      *websocket.Conn
+	closed      bool
+	lastMessage atomic.Int64 // Unix nanoseconds of the last websocket message
+}
+
+func NewObserver(logger *zap.Logger, b *board.Board, token string, config *websocket.DialOptions) *Observer {
+	return &Observer{
+		Logger:   logger,
+		Board:    b,
+		Token:    token,
+		WSconfig: config,
+		Clock:    util.RealClock,
+	}
+}
+
+// Run follows the canvas until Close, it subscribes again with a backoff every time the websocket closes
+func (o *Observer) Run() {
+	backoff := time.Second
+
+	for !o.isClosed() {
+		start := o.clock().Now()
+		o.follow()
+
+		if o.isClosed() {
+			return
+		}
+
+		if o.lastMessage.Load() >= start.UnixNano() {
+			backoff = time.Second // A message came since we connected, it's not the server refusing us
+		} else if backoff < time.Minute {
+			backoff *= 2
+		}
+
+		o.Info("Following the canvas again", zap.Duration("in", backoff))
+		<-o.clock().After(backoff)
+		reconnects.Inc("observer")
+	}
+}
+
+func (o *Observer) follow() {
+	endpoints := o.Endpoints
+	if endpoints == nil {
+		endpoints = DefaultEndpoints
+	}
+
+	conn, _, err := websocket.Dial(context.Background(), endpoints.Socket, o.WSconfig)
+	if err != nil {
+		o.Error("Failed to connect to websocket", zap.Error(err))
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "observer closed connection")
+
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	o.socket = conn
+	o.mu.Unlock()
+
+	sub := &subscription{
+		Logger:     o.Logger,
+		board:      o.Board,
+		controller: o,
+		token:      o.Token,
+		teamOwner:  endpoints.TeamOwner,
+		seen:       func() { o.lastMessage.Store(o.clock().Now().UnixNano()) },
+	}
+
+	if err = sub.follow(conn); err != nil {
+		o.Warn("Stopped following the canvas", zap.Error(err))
+	}
+}
+
+// Healthy reports if the websocket of the observer received a message during the last timeout
+func (o *Observer) Healthy(now time.Time, timeout time.Duration) bool {
+	last := o.lastMessage.Load()
+	return last != 0 && now.Sub(time.Unix(0, last)) < timeout
+}
+
+// Reconnect drops the websocket, Run subscribes again and the board gets full frames
+func (o *Observer) Reconnect() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.socket != nil {
+		o.socket.Close(websocket.StatusNormalClosure, "reconnecting")
+		o.socket = nil
+	}
+}
+
+// Close stops following the canvas
+func (o *Observer) Close() {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+
+	o.Reconnect()
+}
+
+func (o *Observer) isClosed() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.closed
+}
+
+func (o *Observer) clock() util.Clock {
+	if o.Clock == nil {
+		return util.RealClock
+	}
+	return o.Clock
+}
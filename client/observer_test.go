@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/util"
+	"github.com/Edouard127/redditplacebot/web"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backoffClock tells the tests every time the observer waits before following the canvas again
+type backoffClock struct {
+	*util.FakeClock
+	waits chan time.Duration
+}
+
+func (c *backoffClock) After(d time.Duration) <-chan time.Time {
+	ch := c.FakeClock.After(d)
+	c.waits <- d
+	return ch
+}
+
+// socketStub stands in for the realtime server, the first subscription gets a full frame of the canvas then the
+// websocket closes, every subscription after it is refused
+type socketStub struct {
+	canvas      int    // The canvas the frame is sent for
+	frame       string // URL of the frame
+	connections atomic.Int32
+}
+
+func (s *socketStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	first := s.connections.Add(1) == 1
+	ctx := context.Background()
+
+	var init web.ConnectionInit
+	if err = wsjson.Read(ctx, conn, &init); err != nil {
+		return
+	}
+
+	if !first {
+		wsjson.Write(ctx, conn, web.ConnectionUnauthorized{Type: "connection_error", Payload: web.Message{Message: "try again later"}})
+		return
+	}
+	if err = wsjson.Write(ctx, conn, web.ConnectionUnauthorized{Type: "connection_ack"}); err != nil {
+		return
+	}
+
+	var configuration web.Subscribe
+	if err = wsjson.Read(ctx, conn, &configuration); err != nil {
+		return
+	}
+
+	var data web.SubscribedData
+	data.Id, data.Type = configuration.Id, "data"
+	data.Payload.Data.Subscribe.Data.ColorPalette.Colors = board.Palette()
+	if err = wsjson.Write(ctx, conn, data); err != nil {
+		return
+	}
+
+	for i := 0; i < 6; i++ {
+		var replace web.Replace
+		if err = wsjson.Read(ctx, conn, &replace); err != nil {
+			return
+		}
+		if replace.Payload.Variables.Input.Channel.Tag != strconv.Itoa(s.canvas) {
+			continue
+		}
+
+		var update web.CanvasUpdate
+		update.Id, update.Type = replace.Id, "data"
+		update.Payload.Data.Subscribe.Data = web.CanvasInfo{Typename: "FullFrameMessageData", Name: s.frame}
+		if err = wsjson.Write(ctx, conn, update); err != nil {
+			return
+		}
+	}
+}
+
+func TestObserverFollowsCanvas(t *testing.T) {
+	at := board.Point{X: 10, Y: 20}
+	b := board.NewBoard()
+	canvas, _ := b.GetCanvasIndex(at)
+	place := at.ToPlacePoint(canvas)
+
+	frame := image.NewNRGBA(image.Rect(0, 0, place.X+1, place.Y+1))
+	frame.Set(place.X, place.Y, color.White)
+
+	stub := &socketStub{canvas: canvas}
+	mux := http.NewServeMux()
+	mux.Handle("/query", stub)
+	mux.HandleFunc("/frame.png", func(w http.ResponseWriter, r *http.Request) { png.Encode(w, frame) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	stub.frame = server.URL + "/frame.png"
+
+	clock := &backoffClock{FakeClock: util.NewFakeClock(time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC)), waits: make(chan time.Duration)}
+	b.Clock = clock
+	b.Observe(at, board.Point{X: at.X + 1, Y: at.Y + 1})
+
+	changed := make(chan map[board.Point]board.Color, 1)
+	b.Listen(func(pixels map[board.Point]board.Color) { changed <- pixels })
+
+	o := NewObserver(zap.NewNop(), b, "", nil)
+	o.Clock = clock
+	o.Endpoints = &Endpoints{Socket: "ws" + strings.TrimPrefix(server.URL, "http") + "/query", TeamOwner: "TEST"}
+
+	done := make(chan struct{})
+	go func() {
+		o.Run()
+		close(done)
+	}()
+
+	select {
+	case pixels := <-changed:
+		if pixels[at] != board.Colors[31] {
+			t.Errorf("the frame changed %v, want %v white", pixels, at)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the frame never reached the board")
+	}
+
+	// The first subscription worked, so the backoff starts over, then it doubles while the server refuses us
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		select {
+		case got := <-clock.waits:
+			if got != want {
+				t.Errorf("backoff %d = %v, want %v", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the observer never waited to follow the canvas again, backoff %d", i)
+		}
+
+		if n := int(stub.connections.Load()); n != i+1 {
+			t.Errorf("connections = %d before the backoff is over, want %d", n, i+1)
+		}
+
+		if i < 2 {
+			clock.Advance(want)
+		}
+	}
+
+	o.Close()
+	clock.Advance(time.Minute)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the observer did not stop")
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"time"
+)
+
+// ErrorKind tells the worker why a placement did not go through
+type ErrorKind int
+
+const (
+	NoError     ErrorKind = iota
+	RateLimited           // The account is on cooldown until NextAvailable
+	Banned                // The account has been banned from r/place
+	Unverified            // The account does not have a verified email
+	AuthExpired           // The access token is no longer accepted
+	Transport             // The request never reached the server, or the response never came back
+	Protocol              // The server answered with something we do not understand
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case NoError:
+		return "none"
+	case RateLimited:
+		return "rate limited"
+	case Banned:
+		return "banned"
+	case Unverified:
+		return "unverified"
+	case AuthExpired:
+		return "auth expired"
+	case Transport:
+		return "transport"
+	case Protocol:
+		return "protocol"
+	}
+
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// PlaceResult is the outcome of a single Client.Place call
+type PlaceResult struct {
+	Point         board.Point
+	Color         board.Color
+	Placed        bool      // The server accepted the pixel
+	PlacedAt      time.Time // When the server says the pixel was placed
+	NextAvailable time.Time // When the client can place again
+	Kind          ErrorKind
+	Err           error
+}
+
+func (r PlaceResult) String() string {
+	if r.Kind == NoError {
+		return fmt.Sprintf("placed=%t next=%s", r.Placed, r.NextAvailable.Format(time.TimeOnly))
+	}
+
+	return fmt.Sprintf("%s: %v", r.Kind, r.Err)
+}
+
+// Usable reports if the client can still place pixels after this result
+func (r PlaceResult) Usable() bool {
+	return r.Kind != Banned && r.Kind != Unverified && r.Kind != AuthExpired
+}
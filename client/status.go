@@ -0,0 +1,98 @@
+package client
+
+import (
+	"time"
+)
+
+// State is the health of an account, it's persisted with the account
+type State string
+
+const (
+	StateActive      State = "active"       // The account can place
+	StateCoolingDown State = "cooling-down" // The account placed and waits until Until
+	StateAuthExpired State = "auth-expired" // The token is no longer accepted, a new login may fix it
+	StateUnverified  State = "unverified"   // The account does not have a verified email
+	StateBanned      State = "banned"       // The account has been banned from r/place
+	StateLoginFailed State = "login-failed" // The browser could not log the account in
+)
+
+// States lists every state an account can be in
+var States = []State{StateActive, StateCoolingDown, StateAuthExpired, StateUnverified, StateBanned, StateLoginFailed}
+
+// Status is the state of an account with why and since when it's in it
+type Status struct {
+	State  State     `json:"state"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"` // End of the cooldown
+}
+
+// At returns the state at the given time, a cooldown that ended is active again
+func (s Status) At(now time.Time) State {
+	switch {
+	case s.State == "":
+		return StateActive
+	case s.State == StateCoolingDown && !now.Before(s.Until):
+		return StateActive
+	}
+
+	return s.State
+}
+
+// Dead reports if the account can't place anymore whatever we do, so it's not worth logging it in
+func (s Status) Dead() bool {
+	return s.State == StateBanned || s.State == StateUnverified
+}
+
+// Broken reports if the account needs a new login before it can place again
+func (s Status) Broken() bool {
+	return s.State == StateAuthExpired || s.State == StateLoginFailed
+}
+
+// CurrentStatus returns the status of the account
+func (cl *Client) CurrentStatus() Status {
+	cl.statusLock.Lock()
+	defer cl.statusLock.Unlock()
+
+	return cl.Status
+}
+
+// SetStatus changes the status of the account, the time it entered the state is kept while the state stays the same
+func (cl *Client) SetStatus(state State, reason string, now time.Time, until time.Time) {
+	cl.statusLock.Lock()
+	defer cl.statusLock.Unlock()
+
+	if cl.Status.State != state {
+		cl.Status.Since = now
+	}
+	cl.Status.State, cl.Status.Reason, cl.Status.Until = state, reason, until
+}
+
+// Observe moves the account to the state the result tells, it reports if the state changed
+func (cl *Client) Observe(result PlaceResult, now time.Time) bool {
+	before := cl.CurrentStatus().State
+
+	reason := ""
+	if result.Err != nil {
+		reason = result.Err.Error()
+	}
+
+	switch result.Kind {
+	case NoError, RateLimited:
+		if result.NextAvailable.After(now) {
+			cl.SetStatus(StateCoolingDown, reason, now, result.NextAvailable)
+		} else {
+			cl.SetStatus(StateActive, reason, now, time.Time{})
+		}
+	case Banned:
+		cl.SetStatus(StateBanned, reason, now, time.Time{})
+	case Unverified:
+		cl.SetStatus(StateUnverified, reason, now, time.Time{})
+	case AuthExpired:
+		cl.SetStatus(StateAuthExpired, reason, now, time.Time{})
+	default: // A transport or protocol error says nothing about the account
+		return false
+	}
+
+	return cl.CurrentStatus().State != before
+}
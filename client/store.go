@@ -0,0 +1,183 @@
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/util"
+	"golang.org/x/crypto/scrypt"
+	"os"
+	"sync"
+)
+
+// AccountStore keeps the credentials and the sessions of the accounts between two runs
+type AccountStore interface {
+	Load() ([]*Client, error)
+	Save(clients []*Client) error
+}
+
+// ErrPassphrase is returned when the account store can't be decrypted
+var ErrPassphrase = errors.New("wrong passphrase or corrupted account store")
+
+// PlainStore is the JSON list of the accounts of data/users.json, kept to migrate from it
+type PlainStore struct {
+	Path string
+}
+
+func (s *PlainStore) Load() (clients []*Client, err error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &clients); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+
+	return clients, nil
+}
+
+func (s *PlainStore) Save(clients []*Client) error {
+	data, err := json.Marshal(clients)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(s.Path, data, 0600)
+}
+
+// EncryptedStore seals the accounts with AES-GCM, the key is derived from a passphrase with scrypt
+type EncryptedStore struct {
+	Path       string
+	passphrase []byte
+
+	mu   sync.Mutex
+	salt []byte // Salt of the cached key, a new store gets a new salt
+	key  []byte
+}
+
+// envelope is the file of an EncryptedStore, everything but the data is in clear
+type envelope struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+const (
+	storeVersion = 1
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+)
+
+func NewEncryptedStore(path string, passphrase []byte) *EncryptedStore {
+	return &EncryptedStore{Path: path, passphrase: passphrase}
+}
+
+func (s *EncryptedStore) Load() ([]*Client, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var e envelope
+	if err = json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+
+	if e.Version != storeVersion || e.KDF != "scrypt" {
+		return nil, fmt.Errorf("%s: unsupported account store version %d (%s)", s.Path, e.Version, e.KDF)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := scrypt.Key(s.passphrase, e.Salt, e.N, e.R, e.P, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.Nonce) != aead.NonceSize() {
+		return nil, ErrPassphrase
+	}
+
+	plain, err := aead.Open(nil, e.Nonce, e.Data, additionalData(e))
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+
+	var clients []*Client
+	if err = json.Unmarshal(plain, &clients); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+
+	s.salt, s.key = e.Salt, key
+	return clients, nil
+}
+
+// Save seals the accounts with a new nonce, the key is only derived again for a new store
+func (s *EncryptedStore) Save(clients []*Client) error {
+	plain, err := json.Marshal(clients)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil {
+		s.salt = make([]byte, 16)
+		if _, err = rand.Read(s.salt); err != nil {
+			return err
+		}
+
+		if s.key, err = scrypt.Key(s.passphrase, s.salt, scryptN, scryptR, scryptP, 32); err != nil {
+			return err
+		}
+	}
+
+	aead, err := newAEAD(s.key)
+	if err != nil {
+		return err
+	}
+
+	e := envelope{Version: storeVersion, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: s.salt, Nonce: make([]byte, aead.NonceSize())}
+	if _, err = rand.Read(e.Nonce); err != nil {
+		return err
+	}
+	e.Data = aead.Seal(nil, e.Nonce, plain, additionalData(e))
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(s.Path, data, 0600)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the parameters in clear to the data, so they can't be changed without failing the decryption
+func additionalData(e envelope) []byte {
+	return []byte(fmt.Sprintf("%d:%s:%d:%d:%d", e.Version, e.KDF, e.N, e.R, e.P))
+}
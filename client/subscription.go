@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/web"
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// subscription follows the configuration and the canvases through a websocket, and feeds them to the board
+type subscription struct {
+	*zap.Logger
+	board      *board.Board
+	controller board.Controller // Who feeds the board, only the frames of the board controller are applied
+	token      string           // Empty for an unauthenticated subscription
+	teamOwner  string
+	packetid   int
+	seen       func() // Called on every message, so we know the websocket is alive
+}
+
+// follow blocks until the websocket closes or the server refuses the subscription
+func (s *subscription) follow(conn *websocket.Conn) error {
+	err := wsjson.Write(context.Background(), conn, web.ConnectionInit{
+		Type: "connection_init",
+		Payload: web.Authorization{
+			Authorization: s.token,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var ack web.ConnectionUnauthorized // Not a sign of life, the server may be refusing us
+	if err = wsjson.Read(context.Background(), conn, &ack); err != nil {
+		return err
+	}
+
+	if ack.Type == "connection_error" {
+		return fmt.Errorf("connection refused: %s", ack.Payload.Message)
+	}
+
+	if err = wsjson.Write(context.Background(), conn, s.configuration()); err != nil {
+		return err
+	}
+
+	var data web.SubscribedData
+	for data.Type != "data" {
+		if err = s.read(conn, &data); err != nil {
+			return err
+		}
+
+		if data.Type == "error" || data.Type == "connection_error" {
+			return fmt.Errorf("configuration refused")
+		}
+	}
+
+	s.board.SetController(s.controller) // Do not remove
+	s.board.SetColors(s.controller, data.Payload.Data.Subscribe.Data.ColorPalette.Colors)
+
+	canvases := make(map[string]int) // subscription id to canvas index
+	for i := 0; i < 6; i++ {
+		replace := s.canvas(fmt.Sprintf("%d", i))
+		canvases[replace.Id] = i
+
+		if err = wsjson.Write(context.Background(), conn, replace); err != nil {
+			return err
+		}
+	}
+
+	for {
+		var canvasData web.CanvasUpdate
+		if err = s.read(conn, &canvasData); err != nil {
+			return err
+		}
+
+		frame := canvasData.Payload.Data.Subscribe.Data
+		frames.Inc(frameType(frame.Typename))
+
+		canvas, ok := canvases[canvasData.Id]
+		if canvasData.Type != "data" || !ok || frame.Name == "" {
+			continue
+		}
+
+		err = s.board.SetCurrentData(s.controller, canvas, frame.Name, frame.Typename == "DiffFrameMessageData")
+		if err != nil {
+			s.Error("Failed to apply canvas frame", zap.Int("canvas", canvas), zap.Error(err))
+		}
+	}
+}
+
+// read reads the next message of the websocket and remembers when it came
+func (s *subscription) read(conn *websocket.Conn, v any) error {
+	err := wsjson.Read(context.Background(), conn, v)
+	if err == nil && s.seen != nil {
+		s.seen()
+	}
+
+	return err
+}
+
+func (s *subscription) configuration() web.Subscribe {
+	s.packetid++
+	return web.Subscribe{
+		Id:   fmt.Sprintf("%d", s.packetid),
+		Type: "start",
+		Payload: web.Var[web.VarInput[web.Input[web.SubscribeConfig]]]{
+			Variables: web.VarInput[web.Input[web.SubscribeConfig]]{
+				Input: web.Input[web.SubscribeConfig]{
+					Channel: web.SubscribeConfig{
+						TeamOwner: s.teamOwner,
+						Category:  "CONFIG",
+					},
+				},
+			},
+			OperationName: "configuration",
+			Query:         "subscription configuration($input: SubscribeInput!) {\n  subscribe(input: $input) {\n    id\n    ... on BasicMessage {\n      data {\n        __typename\n        ... on ConfigurationMessageData {\n          colorPalette {\n            colors {\n              hex\n              index\n              __typename\n            }\n            __typename\n          }\n          canvasConfigurations {\n            index\n            dx\n            dy\n            __typename\n          }\n          activeZone {\n            topLeft {\n              x\n              y\n              __typename\n            }\n            bottomRight {\n              x\n              y\n              __typename\n            }\n            __typename\n          }\n          canvasWidth\n          canvasHeight\n          adminConfiguration {\n            maxAllowedCircles\n            maxUsersPerAdminBan\n            __typename\n          }\n          __typename\n        }\n      }\n      __typename\n    }\n    __typename\n  }\n}\n",
+		},
+	}
+}
+
+func (s *subscription) canvas(tag string) web.Replace {
+	s.packetid++
+	return web.Replace{
+		Id:   fmt.Sprintf("%d", s.packetid),
+		Type: "start",
+		Payload: web.Var[web.VarInput[web.Input[web.SubscribeReplace]]]{
+			Variables: web.VarInput[web.Input[web.SubscribeReplace]]{
+				Input: web.Input[web.SubscribeReplace]{
+					Channel: web.SubscribeReplace{
+						TeamOwner: s.teamOwner,
+						Category:  "CANVAS",
+						Tag:       tag,
+					},
+				},
+			},
+			OperationName: "replace",
+			Query:         "subscription replace($input: SubscribeInput!) {\n  subscribe(input: $input) {\n    id\n    ... on BasicMessage {\n      data {\n        __typename\n        ... on FullFrameMessageData {\n          __typename\n          name\n          timestamp\n        }\n        ... on DiffFrameMessageData {\n          __typename\n          name\n          currentTimestamp\n          previousTimestamp\n        }\n      }\n      __typename\n    }\n    __typename\n  }\n}\n",
+		},
+	}
+}
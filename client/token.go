@@ -0,0 +1,124 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-rod/rod/lib/proto"
+	"strings"
+	"time"
+)
+
+// TokenLifetime is how long a token is assumed to live when its expiry can't be decoded
+var TokenLifetime = time.Hour
+
+// tokenExpiry decodes the expiry of a JWT bearer token, the zero time if it's not a JWT or has no expiry
+func tokenExpiry(token string) time.Time {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(int64(claims.Exp * 1000))
+}
+
+// Expiry returns when the access token expires, from the token itself or from when it was issued,
+// the zero time if it's unknown
+func (cl *Client) Expiry() time.Time {
+	cl.tokenLock.RLock()
+	defer cl.tokenLock.RUnlock()
+
+	if expiry := tokenExpiry(cl.AccessToken); !expiry.IsZero() {
+		return expiry
+	}
+
+	if cl.AccessToken != "" && !cl.TokenIssued.IsZero() {
+		return cl.TokenIssued.Add(TokenLifetime)
+	}
+
+	return time.Time{}
+}
+
+// NeedsRefresh reports if the client has no token, if the server refused it, or if it expires within the margin
+func (cl *Client) NeedsRefresh(now time.Time, margin time.Duration) bool {
+	if cl.token() == "" || cl.CurrentStatus().State == StateAuthExpired {
+		return true
+	}
+
+	expiry := cl.Expiry()
+	return !expiry.IsZero() && !now.Add(margin).Before(expiry)
+}
+
+// Refresh gets a new token through the session cookies, the client keeps placing with the old one meanwhile,
+// then its websocket follows the canvas with the new one if it had one
+func (cl *Client) Refresh() error {
+	if err := cl.authenticate(true); err != nil {
+		return err
+	}
+
+	if cl.websocket() != nil {
+		cl.Reconnect()
+	}
+	return nil
+}
+
+func (cl *Client) token() string {
+	cl.tokenLock.RLock()
+	defer cl.tokenLock.RUnlock()
+
+	return cl.AccessToken
+}
+
+func (cl *Client) cookies() []*proto.NetworkCookie {
+	cl.tokenLock.RLock()
+	defer cl.tokenLock.RUnlock()
+
+	return cl.Cookies
+}
+
+func (cl *Client) setToken(token string, issued time.Time) {
+	cl.tokenLock.Lock()
+	defer cl.tokenLock.Unlock()
+
+	cl.AccessToken, cl.TokenIssued = token, issued
+}
+
+// setSession changes the cookies and the token together, so they are never saved from two different logins
+func (cl *Client) setSession(cookies []*proto.NetworkCookie, token string, issued time.Time) {
+	cl.tokenLock.Lock()
+	defer cl.tokenLock.Unlock()
+
+	cl.Cookies, cl.AccessToken, cl.TokenIssued = cookies, token, issued
+}
+
+// MarshalJSON writes the account as it is saved in the account store, the token and the status are read under
+// their locks since the client keeps placing and refreshing while the accounts are saved
+func (cl *Client) MarshalJSON() ([]byte, error) {
+	type account Client // Without the methods, so it does not call itself
+
+	cl.tokenLock.RLock()
+	snapshot := account{
+		Username:    cl.Username,
+		Password:    cl.Password,
+		AccessToken: cl.AccessToken,
+		TokenIssued: cl.TokenIssued,
+		Cookies:     cl.Cookies,
+	}
+	cl.tokenLock.RUnlock()
+
+	snapshot.Status = cl.CurrentStatus()
+	return json.Marshal(&snapshot)
+}
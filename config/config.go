@@ -0,0 +1,414 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultPath is where the config file is read from when no other is given
+const DefaultPath = "config.json"
+
+// Config is every setting of the bot, the defaults are overridden by the config file, then the environment, then the flags
+type Config struct {
+	Endpoints Endpoints         `json:"endpoints"`
+	Headers   map[string]string `json:"headers"`   // Sent when opening the websocket, it refuses connections without the headers of a browser
+	Accounts  string            `json:"accounts"`  // Path of the encrypted account store
+	Manifest  string            `json:"manifest"`  // Path of a JSON list of templates, replaces Templates when set
+	Templates []Template        `json:"templates"` // Templates drawn when there's no manifest
+	Observer  Observer          `json:"observer"`
+	Scheduler Scheduler         `json:"scheduler"`
+	API       API               `json:"api"`
+	Dashboard Dashboard         `json:"dashboard"`
+	Metrics   Metrics           `json:"metrics"`
+	Journal   Journal           `json:"journal"`
+	Log       Log               `json:"log"`
+}
+
+type Endpoints struct {
+	Socket    string `json:"socket"`
+	Query     string `json:"query"`
+	Origin    string `json:"origin"`
+	TeamOwner string `json:"teamOwner"`
+	Proxy     string `json:"proxy"` // URL of the SOCKS5 proxy, like socks5://127.0.0.1:9050, empty to connect directly
+}
+
+type Template struct {
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Strategy string `json:"strategy"`
+}
+
+type Observer struct {
+	Enabled bool   `json:"enabled"`
+	Token   string `json:"token"` // Access token of a dedicated account, empty to follow the canvas without one
+}
+
+type Scheduler struct {
+	LeaseTTL      Duration `json:"leaseTTL"`
+	Recent        Duration `json:"recent"`
+	VerifyTimeout Duration `json:"verifyTimeout"`
+	FrameTimeout  Duration `json:"frameTimeout"`  // How long the board can go without a frame before another client feeds it
+	RefreshMargin Duration `json:"refreshMargin"` // How long before their expiry the access tokens are refreshed
+}
+
+// API is the local HTTP server controlling the running bot
+type API struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"` // Address of the server, keep it on localhost
+	Token   string `json:"token"`  // Required by every request, a random one is logged when it's empty
+}
+
+// Dashboard is the local page showing the canvas, the templates and the accounts, it can't change anything
+type Dashboard struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+}
+
+// Metrics is the endpoint scraped by Prometheus
+type Metrics struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+}
+
+// Journal is the file the leases and the placements are appended to, it's read again when the bot starts
+type Journal struct {
+	Path      string `json:"path"`      // Empty to keep nothing
+	MaxSizeMB int    `json:"maxSizeMB"` // Size at which the file is rotated
+	Keep      int    `json:"keep"`      // How many rotated files are kept
+}
+
+type Log struct {
+	Level       string `json:"level"` // debug, info, warn or error
+	Development bool   `json:"development"`
+}
+
+// Duration is a time.Duration written like "30s" in the config file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations are strings like \"30s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func Default() *Config {
+	return &Config{
+		Endpoints: Endpoints{
+			Socket:    "wss://gql-realtime-2.reddit.com/query",
+			Query:     "https://gql-realtime-2.reddit.com/query",
+			Origin:    "https://hot-potato.reddit.com",
+			TeamOwner: "GARLICBREAD",
+			Proxy:     "socks5://127.0.0.1:9050",
+		},
+		Headers: map[string]string{
+			"Accept-Encoding":          "gzip, deflate, br",
+			"Accept-Language":          "en-GB,en-US;q=0.9,en;q=0.8",
+			"Cache-Control":            "no-cache",
+			"Pragma":                   "no-cache",
+			"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits",
+			"Sec-WebSocket-Key":        "ito9k+J7oZkTKA3y7IS/Zw==",
+			"Sec-WebSocket-Version":    "13",
+			"Upgrade":                  "websocket",
+			"Origin":                   "https://garlic-bread.reddit.com",
+			"User-Agent":               "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36 OPR/100.0.0.0 (Edition std-2)",
+		},
+		Accounts:  "data/accounts.enc",
+		Templates: []Template{{Name: "image", Image: "../data/image.bmp", Strategy: "random"}},
+		Observer:  Observer{Enabled: true},
+		Scheduler: Scheduler{
+			LeaseTTL:      Duration(time.Minute),
+			Recent:        Duration(45 * time.Second),
+			VerifyTimeout: Duration(30 * time.Second),
+			FrameTimeout:  Duration(30 * time.Second),
+			RefreshMargin: Duration(10 * time.Minute),
+		},
+		API:       API{Listen: "127.0.0.1:8321"},
+		Dashboard: Dashboard{Listen: "127.0.0.1:8322"},
+		Metrics:   Metrics{Listen: "127.0.0.1:9321"},
+		Journal:   Journal{Path: "data/journal.jsonl", MaxSizeMB: 10, Keep: 5},
+		Log:       Log{Level: "info", Development: true},
+	}
+}
+
+// Load reads the config file over the defaults, then applies the environment and the flags given on the command line,
+// a missing file is only an error when it's required
+func Load(path string, required bool, flags *Flags) (*Config, error) {
+	c := Default()
+
+	if err := c.read(path); err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+		return nil, err
+	}
+
+	if err := c.environment(readEnvFile(".env")); err != nil {
+		return nil, err
+	}
+
+	if flags != nil {
+		if err := flags.apply(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) read(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// encoding/json decodes the elements of a slice over the ones already there, so the templates of the file would get
+	// the fields of the default ones they leave out
+	defaults := c.Templates
+	c.Templates = nil
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(c); err != nil {
+		c.Templates = defaults
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if c.Templates == nil {
+		c.Templates = defaults
+	}
+	for i, t := range c.Templates {
+		if t.Name == "" {
+			c.Templates[i].Name = fmt.Sprintf("template-%d", i)
+		}
+		if t.Strategy == "" {
+			c.Templates[i].Strategy = "random"
+		}
+	}
+
+	return nil
+}
+
+// environment applies the environment variables, the ones of the .env file come after the real ones
+func (c *Config) environment(file map[string]string) error {
+	lookup := func(name string) (string, bool) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			value, ok = file[name]
+		}
+		return value, ok
+	}
+
+	for _, s := range settings {
+		value, ok := lookup(s.env)
+		if !ok && s.legacy != nil {
+			value, ok = s.legacy(lookup)
+		}
+		if !ok {
+			continue
+		}
+
+		if err := s.set(c, value); err != nil {
+			return fmt.Errorf("%s: %w", s.env, err)
+		}
+	}
+
+	return nil
+}
+
+// readEnvFile reads the KEY=value lines of a .env file, it's fine if there's none
+func readEnvFile(path string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+
+	return values
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	endpoints := []struct{ name, url string }{
+		{"endpoints.socket", c.Endpoints.Socket},
+		{"endpoints.query", c.Endpoints.Query},
+		{"endpoints.origin", c.Endpoints.Origin},
+	}
+	for _, endpoint := range endpoints {
+		if u, err := url.Parse(endpoint.url); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: %q is not an absolute URL", endpoint.name, endpoint.url))
+		}
+	}
+
+	if c.Endpoints.TeamOwner == "" {
+		errs = append(errs, errors.New("endpoints.teamOwner is empty"))
+	}
+
+	if c.Endpoints.Proxy != "" && !isSOCKS5(c.Endpoints.Proxy) {
+		errs = append(errs, fmt.Errorf("endpoints.proxy: %q is not a socks5:// URL", c.Endpoints.Proxy))
+	}
+
+	if c.Accounts == "" {
+		errs = append(errs, errors.New("accounts is empty"))
+	}
+
+	if c.Manifest == "" && len(c.Templates) == 0 {
+		errs = append(errs, errors.New("there are no templates and no manifest"))
+	}
+
+	for i, t := range c.Templates {
+		if t.Image == "" {
+			errs = append(errs, fmt.Errorf("templates[%d]: image is empty", i))
+		}
+	}
+
+	durations := []struct {
+		name string
+		d    Duration
+	}{
+		{"scheduler.leaseTTL", c.Scheduler.LeaseTTL},
+		{"scheduler.recent", c.Scheduler.Recent},
+		{"scheduler.verifyTimeout", c.Scheduler.VerifyTimeout},
+		{"scheduler.frameTimeout", c.Scheduler.FrameTimeout},
+		{"scheduler.refreshMargin", c.Scheduler.RefreshMargin},
+	}
+	for _, duration := range durations {
+		if duration.d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", duration.name))
+		}
+	}
+
+	if c.API.Enabled {
+		if host, _, err := net.SplitHostPort(c.API.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("api.listen: %w", err))
+		} else if c.API.Token == "" && !isLoopback(host) {
+			errs = append(errs, fmt.Errorf("api.token must be given when the API listens on %q, a random token is only made for a loopback address", c.API.Listen))
+		}
+	}
+
+	if c.Dashboard.Enabled {
+		if _, _, err := net.SplitHostPort(c.Dashboard.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("dashboard.listen: %w", err))
+		}
+	}
+
+	if c.Metrics.Enabled {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.listen: %w", err))
+		}
+	}
+
+	if c.Journal.Path != "" {
+		if c.Journal.MaxSizeMB <= 0 {
+			errs = append(errs, errors.New("journal.maxSizeMB must be positive"))
+		}
+		if c.Journal.Keep < 0 {
+			errs = append(errs, errors.New("journal.keep can't be negative"))
+		}
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level: unknown level %q", c.Log.Level))
+	}
+
+	return errors.Join(errs...)
+}
+
+// isLoopback reports if the host only accepts connections from this machine, an empty host listens everywhere
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isSOCKS5(proxy string) bool {
+	u, err := url.Parse(proxy)
+	return err == nil && u.Scheme == "socks5" && u.Host != ""
+}
+
+// ProxyAddress is the host:port of the proxy, empty to connect directly
+func (c *Config) ProxyAddress() string {
+	if c.Endpoints.Proxy == "" {
+		return ""
+	}
+
+	u, err := url.Parse(c.Endpoints.Proxy)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// LoadTemplates returns the templates of the manifest, or the ones of the config when there's no manifest
+func (c *Config) LoadTemplates() ([]Template, error) {
+	if c.Manifest == "" {
+		return c.Templates, nil
+	}
+
+	data, err := os.ReadFile(c.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []Template
+	if err = json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Manifest, err)
+	}
+
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("%s: there are no templates", c.Manifest)
+	}
+
+	for i, t := range templates {
+		if t.Name == "" {
+			templates[i].Name = fmt.Sprintf("template-%d", i)
+		}
+		if t.Image == "" {
+			return nil, fmt.Errorf("%s: template %d has no image", c.Manifest, i)
+		}
+	}
+
+	return templates, nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateAPIToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		listen  string
+		token   string
+		wantErr bool
+	}{
+		{name: "loopback without a token", listen: "127.0.0.1:8321"},
+		{name: "localhost without a token", listen: "localhost:8321"},
+		{name: "IPv6 loopback without a token", listen: "[::1]:8321"},
+		{name: "every interface without a token", listen: ":8321", wantErr: true},
+		{name: "public address without a token", listen: "192.0.2.1:8321", wantErr: true},
+		{name: "public address with a token", listen: "0.0.0.0:8321", token: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Templates = []Template{{Name: "test", Image: "test.bmp"}}
+			c.API.Enabled, c.API.Listen, c.API.Token = true, tt.listen, tt.token
+
+			err := c.Validate()
+			if got := err != nil && strings.Contains(err.Error(), "api.token"); got != tt.wantErr {
+				t.Errorf("Validate = %v, want an api.token error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadTemplates(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []Template
+	}{
+		{name: "defaults kept", file: `{}`, want: Default().Templates},
+		{name: "fields of the default not inherited", file: `{"templates": [{"image": "a.bmp", "x": 3}]}`, want: []Template{{Name: "template-0", Image: "a.bmp", X: 3, Strategy: "random"}}},
+		{name: "every field given", file: `{"templates": [{"name": "a", "image": "a.bmp", "strategy": "spiral"}, {"name": "b", "image": "b.bmp"}]}`, want: []Template{{Name: "a", Image: "a.bmp", Strategy: "spiral"}, {Name: "b", Image: "b.bmp", Strategy: "random"}}},
+		{name: "no templates", file: `{"templates": []}`, want: []Template{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			c := Default()
+			if err := c.read(path); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(c.Templates, tt.want) {
+				t.Errorf("templates = %+v, want %+v", c.Templates, tt.want)
+			}
+		})
+	}
+}
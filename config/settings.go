@@ -0,0 +1,148 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// setting is a single value of the config that can be overridden by an environment variable and a flag
+type setting struct {
+	flag    string
+	env     string
+	legacy  func(env func(name string) (string, bool)) (string, bool) // Reads an older variable when env is not set
+	usage   string
+	boolean bool
+	set     func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{flag: "socket", env: "PLACEBOT_SOCKET", usage: "URL of the canvas websocket", set: func(c *Config, v string) error { c.Endpoints.Socket = v; return nil }},
+	{flag: "query", env: "PLACEBOT_QUERY", usage: "URL of the GraphQL endpoint", set: func(c *Config, v string) error { c.Endpoints.Query = v; return nil }},
+	{flag: "teamOwner", env: "PLACEBOT_TEAM_OWNER", usage: "Owner of the canvas channels", set: func(c *Config, v string) error { c.Endpoints.TeamOwner = v; return nil }},
+	{flag: "proxy", env: "PLACEBOT_PROXY", legacy: legacyProxy, usage: "URL of the SOCKS5 proxy, empty to connect directly", set: func(c *Config, v string) error { c.Endpoints.Proxy = v; return nil }},
+	{flag: "accounts", env: "PLACEBOT_ACCOUNTS", usage: "Path of the account store", set: func(c *Config, v string) error { c.Accounts = v; return nil }},
+	{flag: "manifest", env: "PLACEBOT_MANIFEST", usage: "Path of a JSON list of templates", set: func(c *Config, v string) error { c.Manifest = v; return nil }},
+	{flag: "image", env: "PLACEBOT_IMAGE", usage: "The BMP image to draw, when there's no manifest", set: func(c *Config, v string) error { c.template().Image = v; return nil }},
+	{flag: "minX", env: "PLACEBOT_MIN_X", usage: "Min X of the image, when there's no manifest", set: func(c *Config, v string) error { return setInt(&c.template().X, v) }},
+	{flag: "minY", env: "PLACEBOT_MIN_Y", usage: "Min Y of the image, when there's no manifest", set: func(c *Config, v string) error { return setInt(&c.template().Y, v) }},
+	{flag: "strategy", env: "PLACEBOT_STRATEGY", usage: "How the next pixel of the image is picked, when there's no manifest", set: func(c *Config, v string) error { c.template().Strategy = v; return nil }},
+	{flag: "observe", env: "PLACEBOT_OBSERVE", usage: "Follow the canvas with an observer, so the board does not depend on the placing clients", boolean: true, set: func(c *Config, v string) error { return setBool(&c.Observer.Enabled, v) }},
+	{flag: "observerToken", env: "PLACEBOT_OBSERVER_TOKEN", usage: "Access token of a dedicated observer account", set: func(c *Config, v string) error { c.Observer.Token = v; return nil }},
+	{flag: "leaseTTL", env: "PLACEBOT_LEASE_TTL", usage: "How long a client can hold a pixel", set: func(c *Config, v string) error { return setDuration(&c.Scheduler.LeaseTTL, v) }},
+	{flag: "recent", env: "PLACEBOT_RECENT", usage: "How long a placed pixel is left alone", set: func(c *Config, v string) error { return setDuration(&c.Scheduler.Recent, v) }},
+	{flag: "verifyTimeout", env: "PLACEBOT_VERIFY_TIMEOUT", usage: "How long the canvas has to show a placement", set: func(c *Config, v string) error { return setDuration(&c.Scheduler.VerifyTimeout, v) }},
+	{flag: "frameTimeout", env: "PLACEBOT_FRAME_TIMEOUT", usage: "How long the board can go without a frame", set: func(c *Config, v string) error { return setDuration(&c.Scheduler.FrameTimeout, v) }},
+	{flag: "refreshMargin", env: "PLACEBOT_REFRESH_MARGIN", usage: "How long before their expiry the access tokens are refreshed", set: func(c *Config, v string) error { return setDuration(&c.Scheduler.RefreshMargin, v) }},
+	{flag: "api", env: "PLACEBOT_API", usage: "Serve the control API", boolean: true, set: func(c *Config, v string) error { return setBool(&c.API.Enabled, v) }},
+	{flag: "apiListen", env: "PLACEBOT_API_LISTEN", usage: "Address of the control API", set: func(c *Config, v string) error { c.API.Listen = v; return nil }},
+	{flag: "apiToken", env: "PLACEBOT_API_TOKEN", usage: "Token required by the control API, a random one is logged when it's empty and the API listens on a loopback address", set: func(c *Config, v string) error { c.API.Token = v; return nil }},
+	{flag: "dashboard", env: "PLACEBOT_DASHBOARD", usage: "Serve the dashboard", boolean: true, set: func(c *Config, v string) error { return setBool(&c.Dashboard.Enabled, v) }},
+	{flag: "dashboardListen", env: "PLACEBOT_DASHBOARD_LISTEN", usage: "Address of the dashboard", set: func(c *Config, v string) error { c.Dashboard.Listen = v; return nil }},
+	{flag: "metrics", env: "PLACEBOT_METRICS", usage: "Serve the Prometheus metrics", boolean: true, set: func(c *Config, v string) error { return setBool(&c.Metrics.Enabled, v) }},
+	{flag: "metricsListen", env: "PLACEBOT_METRICS_LISTEN", usage: "Address of the Prometheus metrics", set: func(c *Config, v string) error { c.Metrics.Listen = v; return nil }},
+	{flag: "journal", env: "PLACEBOT_JOURNAL", usage: "Path of the placement journal, empty to keep nothing", set: func(c *Config, v string) error { c.Journal.Path = v; return nil }},
+	{flag: "journalMaxSize", env: "PLACEBOT_JOURNAL_MAX_SIZE", usage: "Size in MB at which the journal is rotated", set: func(c *Config, v string) error { return setInt(&c.Journal.MaxSizeMB, v) }},
+	{flag: "journalKeep", env: "PLACEBOT_JOURNAL_KEEP", usage: "How many rotated journals are kept", set: func(c *Config, v string) error { return setInt(&c.Journal.Keep, v) }},
+	{flag: "log", env: "PLACEBOT_LOG", usage: "Log level, debug, info, warn or error", set: func(c *Config, v string) error { c.Log.Level = v; return nil }},
+}
+
+// legacyProxy reads HTTP_PROXY, which held the proxy before PLACEBOT_PROXY, it's only used when it's a SOCKS5 URL
+// since the variable is shared with every program and usually points to an HTTP proxy
+func legacyProxy(env func(name string) (string, bool)) (string, bool) {
+	value, ok := env("HTTP_PROXY")
+	if !ok || !isSOCKS5(value) {
+		return "", false
+	}
+	return value, true
+}
+
+// template is the template the single image settings apply to
+func (c *Config) template() *Template {
+	if len(c.Templates) == 0 {
+		c.Templates = append(c.Templates, Template{Name: "image"})
+	}
+	return &c.Templates[0]
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*dst = b
+	return nil
+}
+
+func setDuration(dst *Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*dst = Duration(d)
+	return nil
+}
+
+// Flags holds the settings given on the command line, only those override the config file and the environment
+type Flags struct {
+	values map[string]*flagValue
+	path   *flagValue
+}
+
+type flagValue struct {
+	value   string
+	set     bool
+	boolean bool
+}
+
+func (f *flagValue) String() string   { return f.value }
+func (f *flagValue) IsBoolFlag() bool { return f.boolean }
+
+func (f *flagValue) Set(value string) error {
+	f.value, f.set = value, true
+	return nil
+}
+
+// RegisterFlags adds the -config flag and a flag for every setting to the flag set
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{values: make(map[string]*flagValue, len(settings)), path: &flagValue{value: DefaultPath}}
+	fs.Var(flags.path, "config", "Path of the config file")
+
+	for _, s := range settings {
+		v := &flagValue{boolean: s.boolean}
+		flags.values[s.flag] = v
+		fs.Var(v, s.flag, fmt.Sprintf("%s (%s)", s.usage, s.env))
+	}
+
+	return flags
+}
+
+// Load loads the config, the file given with -config must exist but the default one may not
+func (f *Flags) Load() (*Config, error) {
+	return Load(f.path.value, f.path.set, f)
+}
+
+func (f *Flags) apply(c *Config) error {
+	for _, s := range settings {
+		v := f.values[s.flag]
+		if v == nil || !v.set {
+			continue
+		}
+
+		if err := s.set(c, v.value); err != nil {
+			return fmt.Errorf("-%s: %w", s.flag, err)
+		}
+	}
+
+	return nil
+}
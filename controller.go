@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"time"
+)
+
+// feeder is a board controller that follows the canvas through a websocket, a client or the observer
+type feeder interface {
+	board.Controller
+	Warn(msg string, fields ...zap.Field)
+	Healthy(now time.Time, timeout time.Duration) bool
+	Reconnect()
+}
+
+// ControllerMonitor moves the control of the board to a healthy feeder when the canvas stops updating,
+// the observer is preferred over the clients so they don't feed the board when it's up
+type ControllerMonitor struct {
+	worker   *Worker
+	board    *board.Board
+	observer *client.Observer // Optional
+	clock    util.Clock
+	timeout  time.Duration // How long the board can go without a frame
+}
+
+func NewControllerMonitor(k *Worker, b *board.Board, observer *client.Observer, clock util.Clock, timeout time.Duration) *ControllerMonitor {
+	return &ControllerMonitor{worker: k, board: b, observer: observer, clock: clock, timeout: timeout}
+}
+
+func (m *ControllerMonitor) Run() {
+	ticker := m.clock.NewTicker(m.timeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		m.check()
+	}
+}
+
+func (m *ControllerMonitor) check() {
+	now := m.clock.Now()
+	current, _ := m.board.Controller().(feeder)
+
+	if m.observer != nil && current != feeder(m.observer) && m.observer.Healthy(now, m.timeout) {
+		m.handOver(current, m.observer) // The observer is back, the clients can stop feeding the board
+		return
+	}
+
+	if now.Sub(m.board.LastFrame()) < m.timeout {
+		return
+	}
+
+	next := m.elect(current, now)
+	if next == nil {
+		if current != nil {
+			current.Warn("The board stopped updating and nobody else can take over, subscribing again")
+			current.Reconnect()
+		}
+		return
+	}
+
+	m.handOver(current, next)
+}
+
+func (m *ControllerMonitor) handOver(current, next feeder) {
+	if current != nil {
+		m.board.ReleaseController(current)
+	}
+	m.board.SetController(next)
+	next.Info("Took over the board", zap.Time("last frame", m.board.LastFrame()))
+	next.Reconnect() // The new subscription starts with full frames, so the board is in sync again
+
+	if current != nil {
+		current.Reconnect()
+	}
+}
+
+// elect picks a client with a live websocket other than the current controller, or any other client if none is live
+func (m *ControllerMonitor) elect(current feeder, now time.Time) feeder {
+	var fallback feeder
+
+	for _, c := range m.worker.Clients() {
+		if current == feeder(c) {
+			continue
+		}
+
+		if c.Healthy(now, m.timeout) {
+			return c
+		}
+
+		if fallback == nil {
+			fallback = c
+		}
+	}
+
+	return fallback
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"embed"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"go.uber.org/zap"
+	"image"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// Dashboard serves a page following the canvas under the templates and the accounts, it only reads
+type Dashboard struct {
+	*zap.Logger
+	worker   *Worker
+	board    *board.Board
+	accounts []*client.Client
+	history  *placementLog
+}
+
+func NewDashboard(logger *zap.Logger, k *Worker, b *board.Board, accounts []*client.Client, placements *placementLog) *Dashboard {
+	return &Dashboard{Logger: logger, worker: k, board: b, accounts: accounts, history: placements}
+}
+
+// ListenAndServe serves the dashboard on the address until it fails
+func (d *Dashboard) ListenAndServe(addr string) error {
+	d.Info("Serving the dashboard", zap.String("address", "http://"+addr))
+	return http.ListenAndServe(addr, d.Handler())
+}
+
+func (d *Dashboard) Handler() http.Handler {
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(files)))
+	mux.HandleFunc("/state.json", d.state)
+	mux.HandleFunc("/canvas.png", d.image(func(_, current *image.NRGBA) *image.NRGBA { return current }))
+	mux.HandleFunc("/template.png", d.image(func(required, _ *image.NRGBA) *image.NRGBA { return required }))
+
+	return mux
+}
+
+// state returns where the images are on the canvas, the templates and the accounts
+func (d *Dashboard) state(w http.ResponseWriter, r *http.Request) {
+	origin, required, _ := d.board.Render()
+
+	state := struct {
+		Time       time.Time        `json:"time"`
+		Origin     board.Point      `json:"origin"`
+		Width      int              `json:"width"`
+		Height     int              `json:"height"`
+		Templates  []templateStatus `json:"templates"`
+		Accounts   []accountStatus  `json:"accounts"`
+		Queue      int              `json:"queue"`
+		Controller string           `json:"controller"`
+		LastFrame  time.Time        `json:"lastFrame"`
+	}{
+		Time:       time.Now(),
+		Origin:     origin,
+		Templates:  describeTemplates(d.worker, d.board.Progress()),
+		Accounts:   describeAccounts(d.worker, d.history, d.accounts),
+		Queue:      d.worker.QueueLength(),
+		Controller: controllerName(d.board.Controller()),
+		LastFrame:  d.board.LastFrame(),
+	}
+	if required != nil {
+		state.Width, state.Height = required.Rect.Dx(), required.Rect.Dy()
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+// image serves one of the images rendered by the board as a PNG
+func (d *Dashboard) image(pick func(required, current *image.NRGBA) *image.NRGBA) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, required, current := d.board.Render()
+		if required == nil {
+			writeError(w, http.StatusServiceUnavailable, "the templates are not loaded yet")
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := png.Encode(w, pick(required, current)); err != nil {
+			d.Debug("Could not send the image", zap.Error(err))
+		}
+	}
+}
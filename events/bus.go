@@ -0,0 +1,110 @@
+package events
+
+import (
+	"github.com/Edouard127/redditplacebot/metrics"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	published = metrics.NewCounter("placebot_events_published_total", "Events published on the bus by name.", "event")
+	dropped   = metrics.NewCounter("placebot_events_dropped_total", "Events a subscriber missed because its buffer was full.", "subscriber")
+)
+
+// Event is something that happened in the bot, its name tells the subscribers that don't know the type what it is
+type Event interface {
+	Name() string
+}
+
+// Bus hands the events to the subscribers without ever blocking the publisher, a subscriber that doesn't keep up misses events.
+// A nil bus drops everything, so the components work without one
+type Bus struct {
+	mu          sync.Mutex
+	subscribers []subscriber
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+type subscriber interface {
+	deliver(e Event)
+}
+
+// Publish gives the event to every subscriber of its type
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	published.Inc(e.Name())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range b.subscribers {
+		s.deliver(e)
+	}
+}
+
+// Subscription receives the events of type E in C until it's closed, subscribe to Event to receive every event
+type Subscription[E Event] struct {
+	C       <-chan E
+	ch      chan E
+	name    string
+	bus     *Bus
+	dropped atomic.Uint64
+	closed  sync.Once
+}
+
+// Subscribe returns a subscription buffering size events, the name tells the subscribers apart in the metrics
+func Subscribe[E Event](b *Bus, name string, size int) *Subscription[E] {
+	ch := make(chan E, size)
+	s := &Subscription[E]{C: ch, ch: ch, name: name, bus: b}
+
+	if b != nil {
+		b.mu.Lock()
+		b.subscribers = append(b.subscribers, s)
+		b.mu.Unlock()
+	}
+
+	return s
+}
+
+func (s *Subscription[E]) deliver(e Event) {
+	event, ok := e.(E)
+	if !ok {
+		return
+	}
+
+	select {
+	case s.ch <- event:
+	default:
+		s.dropped.Add(1)
+		dropped.Inc(s.name)
+	}
+}
+
+// Dropped counts the events the subscription missed because its buffer was full
+func (s *Subscription[E]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription and closes C
+func (s *Subscription[E]) Close() {
+	s.closed.Do(func() {
+		if s.bus != nil {
+			s.bus.mu.Lock()
+			defer s.bus.mu.Unlock() // Publish sends under the lock, so nothing is sent on the closed channel
+
+			for i, sub := range s.bus.subscribers {
+				if sub == subscriber(s) {
+					s.bus.subscribers = append(s.bus.subscribers[:i], s.bus.subscribers[i+1:]...)
+					break
+				}
+			}
+		}
+
+		close(s.ch)
+	})
+}
@@ -0,0 +1,97 @@
+package events
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"time"
+)
+
+// PixelLeased is a pixel given to a client, no other client gets it until Expires
+type PixelLeased struct {
+	Time     time.Time      `json:"time"`
+	Client   *client.Client `json:"-"`
+	Username string         `json:"username"`
+	Template string         `json:"template"`
+	Point    board.Point    `json:"point"`
+	Color    board.Color    `json:"color"`
+	Expires  time.Time      `json:"expires"`
+}
+
+func (PixelLeased) Name() string { return "pixel-leased" }
+
+// PixelPlaced is the answer of the server to a pixel a client sent
+type PixelPlaced struct {
+	Time     time.Time      `json:"time"`
+	Client   *client.Client `json:"-"`
+	Username string         `json:"username"`
+	Template string         `json:"template"`
+	Point    board.Point    `json:"point"`
+	Color    board.Color    `json:"color"`
+	Outcome  string         `json:"outcome"` // placed or the kind of error
+	Error    string         `json:"error,omitempty"`
+	Next     time.Time      `json:"next"` // When the client can place again
+}
+
+func (PixelPlaced) Name() string { return "pixel-placed" }
+
+// PixelVerified is what the canvas or the pixel history says about a placed pixel
+type PixelVerified struct {
+	Time     time.Time      `json:"time"`
+	Client   *client.Client `json:"-"`
+	Username string         `json:"username"`
+	Point    board.Point    `json:"point"`
+	Color    board.Color    `json:"color"`
+	Outcome  string         `json:"outcome"` // confirmed, overwritten after, never landed or unverified
+}
+
+func (PixelVerified) Name() string { return "pixel-verified" }
+
+// PixelDamaged is a pixel of a template that the canvas changed to another color
+type PixelDamaged struct {
+	Time     time.Time   `json:"time"`
+	Template string      `json:"template"`
+	Point    board.Point `json:"point"`
+	Expected board.Color `json:"expected"`
+	Actual   board.Color `json:"actual"`
+}
+
+func (PixelDamaged) Name() string { return "pixel-damaged" }
+
+// FrameApplied is a canvas frame that changed pixels of the board
+type FrameApplied struct {
+	Time    time.Time `json:"time"`
+	Changed int       `json:"changed"`
+}
+
+func (FrameApplied) Name() string { return "frame-applied" }
+
+// ClientStateChanged is an account moving to another state, like banned or cooling down
+type ClientStateChanged struct {
+	Time     time.Time      `json:"time"`
+	Client   *client.Client `json:"-"`
+	Username string         `json:"username"`
+	From     client.State   `json:"from"`
+	To       client.State   `json:"to"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+func (ClientStateChanged) Name() string { return "client-state-changed" }
+
+// ClientChanged is an account joining, leaving, paused or resumed in the worker
+type ClientChanged struct {
+	Time     time.Time      `json:"time"`
+	Client   *client.Client `json:"-"`
+	Username string         `json:"username"`
+	Change   string         `json:"change"` // joined, left, paused or resumed
+}
+
+func (ClientChanged) Name() string { return "client-changed" }
+
+// TemplateChanged is a template enabled, disabled or read again
+type TemplateChanged struct {
+	Time     time.Time `json:"time"`
+	Template string    `json:"template,omitempty"` // Empty when every template was reloaded
+	Change   string    `json:"change"`             // enabled, disabled or reloaded
+}
+
+func (TemplateChanged) Name() string { return "template-changed" }
@@ -5,7 +5,9 @@ go 1.20
 require (
 	github.com/go-rod/rod v0.114.0
 	github.com/sergeymakinen/go-bmp v1.0.0-beta.1
+	github.com/ysmood/gson v0.7.3
 	go.uber.org/zap v1.24.0
+	golang.org/x/crypto v0.12.0
 	golang.org/x/net v0.12.0
 	nhooyr.io/websocket v1.8.7
 )
@@ -15,7 +17,6 @@ require (
 	github.com/ysmood/fetchup v0.2.3 // indirect
 	github.com/ysmood/goob v0.4.0 // indirect
 	github.com/ysmood/got v0.34.1 // indirect
-	github.com/ysmood/gson v0.7.3 // indirect
 	github.com/ysmood/leakless v0.8.0 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/config"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Ownership is who last touched a pixel of the canvas
+type Ownership struct {
+	X         int       `json:"x"`
+	Y         int       `json:"y"`
+	Color     string    `json:"color"`
+	Username  string    `json:"username"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// inspect writes who last touched every pixel of a rectangle, using a single account
+func inspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	minX, minY := flags.Int("minX", 0, "Min X"), flags.Int("minY", 0, "Min Y")
+	maxX, maxY := flags.Int("maxX", 0, "Max X (exclusive)"), flags.Int("maxY", 0, "Max Y (exclusive)")
+	format := flags.String("format", "csv", "Output format, csv or json")
+	output := flags.String("o", "", "Output file, stdout if empty")
+	username := flags.String("user", "", "Account used to query the history, the first one if empty")
+	rate := flags.Duration("rate", 500*time.Millisecond, "Delay between two history requests")
+	wait := flags.Duration("wait", 10*time.Second, "How long to wait for the canvas colors")
+	path := flags.String("config", config.DefaultPath, "Path of the config file")
+	flags.Parse(args)
+
+	cfg, err := config.Load(*path, *path != config.DefaultPath, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return exitUsage
+	}
+
+	if *maxX <= *minX || *maxY <= *minY {
+		fmt.Fprintln(os.Stderr, "The rectangle is empty, maxX and maxY must be greater than minX and minY")
+		return exitUsage
+	}
+
+	if err = board.CheckBounds(board.Point{X: *minX, Y: *minY}, *maxX-*minX, *maxY-*minY); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid rectangle:", err)
+		return exitUsage
+	}
+
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	logger := newLogger(cfg)
+	browser, err := client.NewBrowser()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	defer browser.Close()
+
+	start, end := board.Point{X: *minX, Y: *minY}, board.Point{X: *maxX, Y: *maxY}
+
+	b := board.NewBoard()
+	b.Observe(start, end)
+
+	_, clients, err := readClients(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	setupClients(cfg, clients, logger, browser, b, util.NewRand(time.Now().UnixNano()))
+
+	c := pickClient(clients, *username)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "No account named %q in %s\n", *username, cfg.Accounts)
+		return exitFailure
+	}
+
+	if err := c.Login(); err != nil {
+		fmt.Fprintln(os.Stderr, "Login failed:", err)
+		return exitFailure
+	}
+
+	b.SetController(c)
+	if !waitForCanvas(b, start, end, *wait) {
+		logger.Warn("The canvas of the rectangle did not fully load, the colors of some pixels are missing", zap.Duration("wait", *wait))
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var owners []Ownership
+	writer := csv.NewWriter(out)
+	if *format == "csv" {
+		writer.Write([]string{"x", "y", "color", "username", "timestamp"})
+	}
+
+	ticker := time.NewTicker(*rate)
+	defer ticker.Stop()
+
+	for x := start.X; x < end.X; x++ {
+		for y := start.Y; y < end.Y; y++ {
+			<-ticker.C
+
+			at := board.Point{X: x, Y: y}
+			canvas, _ := b.GetCanvasIndex(at) // The rectangle was checked with the arguments
+			last, err := c.GetPlaceHistory(at, canvas)
+			if err != nil {
+				c.Warn("Could not fetch the pixel history", zap.Any("point", at), zap.Error(err))
+				continue
+			}
+
+			owner := Ownership{
+				X:         x,
+				Y:         y,
+				Username:  last.UserInfo.Username,
+				Timestamp: time.UnixMilli(int64(last.LastModified)),
+			}
+			if color, ok := b.CurrentColor(at); ok {
+				owner.Color = color.Hex()
+			}
+
+			if *format == "csv" {
+				writer.Write([]string{strconv.Itoa(x), strconv.Itoa(y), owner.Color, owner.Username, owner.Timestamp.Format(time.RFC3339)})
+				writer.Flush()
+			} else {
+				owners = append(owners, owner)
+			}
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(owners); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+
+	return exitOK
+}
+
+func pickClient(clients []*client.Client, username string) *client.Client {
+	for _, c := range clients {
+		if username == "" || c.Username == username {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// waitForCanvas waits until the board knows the color of every pixel of the rectangle, it returns false when the timeout
+// expires first
+func waitForCanvas(b *board.Board, start, end board.Point, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if known(b, start, end) {
+			return true
+		}
+
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// known reports if the board knows the color of every pixel of the rectangle, the frames of a canvas come in pieces
+func known(b *board.Board, start, end board.Point) bool {
+	for x := start.X; x < end.X; x++ {
+		for y := start.Y; y < end.Y; y++ {
+			if _, ok := b.CurrentColor(board.Point{X: x, Y: y}); !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"testing"
+)
+
+func TestWaitForCanvas(t *testing.T) {
+	start, end := board.Point{}, board.Point{X: 2, Y: 2}
+	white := board.Colors[31]
+
+	tests := []struct {
+		name   string
+		pixels []board.Point
+		want   bool
+	}{
+		{name: "nothing loaded", want: false},
+		{name: "last pixel only", pixels: []board.Point{{X: 1, Y: 1}}, want: false},
+		{name: "every pixel", pixels: []board.Point{{}, {X: 1}, {Y: 1}, {X: 1, Y: 1}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := board.NewBoard()
+			b.Observe(start, end)
+			b.SetController(testController{})
+
+			pixels := make(map[board.Point]board.Color)
+			for _, at := range tt.pixels {
+				pixels[at] = white
+			}
+			b.SetPixels(testController{}, pixels)
+
+			if got := waitForCanvas(b, start, end, 0); got != tt.want {
+				t.Errorf("waitForCanvas = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Package clienttest helps testing the code that logs the accounts in
+package clienttest
+
+import (
+	"context"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// FakeAuthenticator logs the accounts in without a browser
+type FakeAuthenticator struct {
+	Passwords map[string]string // Username to password
+	Token     func(username string) string
+	Err       error // Returned by every login when set
+}
+
+func (a *FakeAuthenticator) Authenticate(ctx context.Context, credentials client.Credentials) (client.Session, error) {
+	if a.Err != nil {
+		return client.Session{}, a.Err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return client.Session{}, err
+	}
+
+	session := client.Session{Cookies: credentials.Cookies}
+	if !hasSession(credentials.Cookies, credentials.Username) {
+		if password, ok := a.Passwords[credentials.Username]; !ok || password != credentials.Password {
+			return client.Session{}, client.ErrLoginRefused
+		}
+
+		session.Cookies = []*proto.NetworkCookie{{Name: "reddit_session", Value: credentials.Username, Domain: ".reddit.com", Path: "/"}}
+	}
+
+	session.Token = "Bearer fake-" + credentials.Username
+	if a.Token != nil {
+		session.Token = a.Token(credentials.Username)
+	}
+
+	return session, nil
+}
+
+func hasSession(cookies []*proto.NetworkCookie, username string) bool {
+	for _, cookie := range cookies {
+		if cookie.Name == "reddit_session" && cookie.Value == username {
+			return true
+		}
+	}
+
+	return false
+}
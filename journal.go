@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/events"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Journal appends the leases, the placements and their verification to a JSON lines file, so a restart picks up where the bot stopped.
+// The file is rotated to path.1, path.2 and so on when it gets bigger than the max size
+type Journal struct {
+	*zap.Logger
+	path    string
+	maxSize int64
+	keep    int // How many rotated files are kept
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+type journalLine struct {
+	Type  string          `json:"type"`
+	Event json.RawMessage `json:"event"`
+}
+
+// OpenJournal opens the journal for appending, it's created when it doesn't exist
+func OpenJournal(logger *zap.Logger, path string, maxSize int64, keep int) (*Journal, error) {
+	j := &Journal{Logger: logger, path: path, maxSize: maxSize, keep: keep}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	j.file, j.size = file, info.Size()
+	return nil
+}
+
+// Follow writes the leases, the placements and the verifications published on the bus until it's closed
+func (j *Journal) Follow(bus *events.Bus) {
+	all := events.Subscribe[events.Event](bus, "journal", 4096)
+
+	go func() {
+		for e := range all.C {
+			switch e.(type) {
+			case events.PixelLeased, events.PixelPlaced, events.PixelVerified:
+				if err := j.Write(e); err != nil {
+					j.Error("Could not write to the journal", zap.String("event", e.Name()), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Write appends the event to the journal, and rotates it when it's too big
+func (j *Journal) Write(e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(journalLine{Type: e.Name(), Event: data})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	n, err := j.file.Write(append(line, '\n'))
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if j.size >= j.maxSize {
+		return j.rotate()
+	}
+
+	return nil
+}
+
+// rotate moves every file one step older and starts a new one, the oldest is dropped, it must be called with the lock held
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+
+	if j.keep == 0 {
+		if err := os.Remove(j.path); err != nil {
+			return err
+		}
+		return j.open()
+	}
+
+	for i := j.keep - 1; i > 0; i-- {
+		if err := os.Rename(j.rotated(i), j.rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := os.Rename(j.path, j.rotated(1)); err != nil {
+		return err
+	}
+
+	j.Debug("Journal rotated", zap.String("path", j.path))
+	return j.open()
+}
+
+func (j *Journal) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", j.path, i)
+}
+
+// Replay reads the journal from the oldest rotated file to the current one, the lines that can't be read are skipped
+func (j *Journal) Replay(fn func(e events.Event)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	paths := []string{j.path}
+	for i := 1; i <= j.keep; i++ {
+		paths = append([]string{j.rotated(i)}, paths...)
+	}
+
+	for _, path := range paths {
+		if err := j.replayFile(path, fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (j *Journal) replayFile(path string, fn func(e events.Event)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		e, err := decodeJournalLine(scanner.Bytes())
+		if err != nil { // Most likely the last line of a process that was killed while writing
+			j.Warn("Skipping a line of the journal", zap.String("path", path), zap.Int("line", n), zap.Error(err))
+			continue
+		}
+
+		fn(e)
+	}
+
+	return scanner.Err()
+}
+
+func decodeJournalLine(data []byte) (events.Event, error) {
+	var line journalLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return nil, err
+	}
+
+	switch line.Type {
+	case events.PixelLeased{}.Name():
+		return decodeEvent[events.PixelLeased](line.Event)
+	case events.PixelPlaced{}.Name():
+		return decodeEvent[events.PixelPlaced](line.Event)
+	case events.PixelVerified{}.Name():
+		return decodeEvent[events.PixelVerified](line.Event)
+	}
+
+	return nil, fmt.Errorf("unknown event %q", line.Type)
+}
+
+func decodeEvent[E events.Event](data json.RawMessage) (events.Event, error) {
+	var e E
+	err := json.Unmarshal(data, &e)
+	return e, err
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// restoreJournal replays the journal, the placement log gets the stats of the accounts back and the worker their cooldowns
+// and their recent placements. The leases are not restored, their pixels are still mismatched so they are queued again
+func restoreJournal(j *Journal, k *Worker, placements *placementLog, clients []*client.Client) error {
+	type account struct {
+		next   time.Time
+		placed map[board.Point]events.PixelPlaced // The last placement of every pixel
+	}
+
+	accounts := make(map[string]*account)
+	get := func(username string) *account {
+		a, ok := accounts[username]
+		if !ok {
+			a = &account{placed: make(map[board.Point]events.PixelPlaced)}
+			accounts[username] = a
+		}
+		return a
+	}
+
+	err := j.Replay(func(e events.Event) {
+		placements.Apply(e)
+
+		if e, ok := e.(events.PixelPlaced); ok {
+			a := get(e.Username)
+			a.next = e.Next
+			if e.Outcome == "placed" {
+				a.placed[e.Point] = e
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range clients {
+		a := accounts[c.Username]
+		if a == nil {
+			continue
+		}
+
+		placed := make([]events.PixelPlaced, 0, len(a.placed))
+		for _, p := range a.placed {
+			placed = append(placed, p)
+		}
+
+		k.Restore(c, a.next, placed)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"sync"
+	"time"
+)
+
+type lease struct {
+	client  *client.Client
+	color   board.Color
+	expires time.Time
+}
+
+// Ledger leases every pixel to a single client, so two clients never fix the same pixel
+type Ledger struct {
+	mu     sync.Mutex
+	ttl    time.Duration // How long a client can hold a pixel before it goes back to the others
+	recent time.Duration // How long a placed pixel is left alone, so the canvas has time to show it
+	leases map[board.Point]lease
+	placed map[board.Point]lease
+}
+
+func NewLedger(ttl, recent time.Duration) *Ledger {
+	return &Ledger{
+		ttl:    ttl,
+		recent: recent,
+		leases: make(map[board.Point]lease),
+		placed: make(map[board.Point]lease),
+	}
+}
+
+// Available reports if the pixel is neither leased nor recently placed with this color
+func (l *Ledger) Available(at board.Point, color board.Color, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.available(at, color, now)
+}
+
+func (l *Ledger) available(at board.Point, color board.Color, now time.Time) bool {
+	if current, ok := l.leases[at]; ok && current.expires.After(now) {
+		return false
+	}
+
+	if placed, ok := l.placed[at]; ok && placed.color == color && placed.expires.After(now) {
+		return false
+	}
+
+	return true
+}
+
+// Lease gives the pixel to the client, it returns false if the pixel is not available
+func (l *Ledger) Lease(c *client.Client, at board.Point, color board.Color, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.available(at, color, now) {
+		return false
+	}
+
+	l.leases[at] = lease{client: c, color: color, expires: now.Add(l.ttl)}
+	return true
+}
+
+// Release gives the pixel back after the client failed to place it
+func (l *Ledger) Release(c *client.Client, at board.Point) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.leases[at]; ok && current.client == c {
+		delete(l.leases, at)
+	}
+}
+
+// ReleaseClient gives back every pixel leased to the client, and returns them
+func (l *Ledger) ReleaseClient(c *client.Client) []Pixel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var released []Pixel
+	for at, current := range l.leases {
+		if current.client == c {
+			delete(l.leases, at)
+			released = append(released, Pixel{At: at, Color: current.color})
+		}
+	}
+
+	return released
+}
+
+// Placed ends the lease of the client and leaves the pixel alone for a while
+func (l *Ledger) Placed(c *client.Client, at board.Point, color board.Color, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.leases[at]; ok && current.client == c {
+		delete(l.leases, at)
+	}
+
+	l.placed[at] = lease{client: c, color: color, expires: now.Add(l.recent)}
+}
+
+// Forget clears the placement of a pixel, so it can be placed again right away
+func (l *Ledger) Forget(at board.Point) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.placed, at)
+}
+
+// Prune drops the leases and the placements that expired
+func (l *Ledger) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for at, current := range l.leases {
+		if !current.expires.After(now) {
+			delete(l.leases, at)
+		}
+	}
+
+	for at, placed := range l.placed {
+		if !placed.expires.After(now) {
+			delete(l.placed, at)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"testing"
+	"time"
+)
+
+func TestLedgerAvailable(t *testing.T) {
+	at, white, black := board.Point{}, board.Colors[31], board.Colors[27]
+	ttl, recent := time.Minute, 45*time.Second
+
+	tests := []struct {
+		name  string
+		setup func(l *Ledger)
+		color board.Color
+		after time.Duration
+		want  bool
+	}{
+		{name: "free", setup: func(l *Ledger) {}, color: white, want: true},
+		{name: "leased", setup: func(l *Ledger) { l.Lease(nil, at, white, testStart) }, color: white, after: ttl - time.Second, want: false},
+		{name: "lease expired", setup: func(l *Ledger) { l.Lease(nil, at, white, testStart) }, color: white, after: ttl, want: true},
+		{name: "released", setup: func(l *Ledger) { l.Lease(nil, at, white, testStart); l.Release(nil, at) }, color: white, want: true},
+		{name: "placed recently", setup: func(l *Ledger) { l.Placed(nil, at, white, testStart) }, color: white, after: recent - time.Second, want: false},
+		{name: "placed with another color", setup: func(l *Ledger) { l.Placed(nil, at, white, testStart) }, color: black, want: true},
+		{name: "placed long ago", setup: func(l *Ledger) { l.Placed(nil, at, white, testStart) }, color: white, after: recent, want: true},
+		{name: "forgotten", setup: func(l *Ledger) { l.Placed(nil, at, white, testStart); l.Forget(at) }, color: white, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLedger(ttl, recent)
+			tt.setup(l)
+
+			if got := l.Available(at, tt.color, testStart.Add(tt.after)); got != tt.want {
+				t.Errorf("Available = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"os"
+	"time"
+)
+
+// login refreshes the sessions of the accounts through the browser and saves them, nothing is drawn
+func login(args []string) int {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	username := flags.String("user", "", "Only log this account in, every account if empty")
+	force := flags.Bool("force", false, "Get a new access token even if the account has one")
+
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return exitUsage
+	}
+
+	store, clients, err := readClients(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	selected := clients
+	if *username != "" {
+		c := pickClient(clients, *username)
+		if c == nil {
+			fmt.Fprintf(os.Stderr, "No account named %q in %s\n", *username, cfg.Accounts)
+			return exitFailure
+		}
+		selected = []*client.Client{c}
+	}
+
+	logger := newLogger(cfg)
+	browser, err := client.NewBrowser()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	defer browser.Close()
+
+	setupClients(cfg, clients, logger, browser, nil, util.NewRand(time.Now().UnixNano()))
+
+	failed := loginClients(selected, *force)
+
+	if err = store.Save(clients); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not save the accounts:", err)
+		return exitFailure
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d accounts could not log in\n", failed, len(selected))
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// loginClients logs the clients in one after the other, and returns how many could not
+func loginClients(clients []*client.Client, force bool) (failed int) {
+	for _, c := range clients { // The browser serves a single client at a time anyway
+		authenticate := c.Authenticate
+		if force {
+			authenticate = c.Refresh
+		}
+
+		if err := authenticate(); err != nil {
+			c.Error("Login failed", zap.Error(err))
+			failed++
+			continue
+		}
+
+		c.Info("Session refreshed")
+	}
+
+	return failed
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/internal/clienttest"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestLoginClients(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		token      string // Token the account already has, issued at the start
+		force      bool
+		err        error // Returned by every login
+		wantFailed int
+		wantToken  string
+	}{
+		{name: "password", password: "hunter2", wantToken: "Bearer fake-alice"},
+		{name: "valid token kept", token: "Bearer old", wantToken: "Bearer old"},
+		{name: "forced refresh", password: "hunter2", token: "Bearer old", force: true, wantToken: "Bearer fake-alice"},
+		{name: "refused password", password: "wrong", wantFailed: 1},
+		{name: "timeout", password: "hunter2", err: context.DeadlineExceeded, wantFailed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := util.NewFakeClock(testStart)
+			c := &client.Client{
+				Logger:        zap.NewNop(),
+				Username:      "alice",
+				Password:      tt.password,
+				AccessToken:   tt.token,
+				TokenIssued:   testStart,
+				Clock:         clock,
+				Authenticator: &clienttest.FakeAuthenticator{Passwords: map[string]string{"alice": "hunter2"}, Err: tt.err},
+			}
+
+			if failed := loginClients([]*client.Client{c}, tt.force); failed != tt.wantFailed {
+				t.Errorf("failed = %d, want %d", failed, tt.wantFailed)
+			}
+			if c.AccessToken != tt.wantToken {
+				t.Errorf("token = %q, want %q", c.AccessToken, tt.wantToken)
+			}
+			if tt.wantFailed == 0 && !c.Expiry().After(clock.Now().Add(time.Minute)) {
+				t.Errorf("the token expires at %v, it should be valid", c.Expiry())
+			}
+		})
+	}
+}
@@ -1,128 +1,382 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/Edouard127/redditplacebot/board"
 	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/config"
 	"github.com/Edouard127/redditplacebot/util"
 	"go.uber.org/zap"
 	"net/http"
 	"nhooyr.io/websocket"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Exit codes of the commands, so they can be scripted
+const (
+	exitOK      = 0
+	exitFailure = 1 // The command ran and failed
+	exitUsage   = 2 // The flags, the configuration or the templates are invalid
+)
+
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands = map[string]command{
+	"run":      {run, "Log the accounts in and draw the templates, the default command"},
+	"login":    {login, "Refresh the sessions of the accounts and save them, without drawing"},
+	"preview":  {preview, "Render the templates quantized to the palette of the canvas as a PNG"},
+	"validate": {validate, "Check the configuration, the templates and the accounts"},
+	"accounts": {accounts, "List, add, remove the accounts or show their status"},
+	"inspect":  {inspect, "Write who last touched every pixel of a rectangle"},
+	"simulate": {simulate, "Run the worker against an in-memory canvas on a simulated clock"},
+}
+
 func main() {
-	logger, _ := zap.NewDevelopment()
-	browser := client.NewBrowser()
-	defer browser.Browser.Close()
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	minX, minY := flag.Int("minX", 0, "Min X"), flag.Int("minY", 0, "Min Y")
-	flag.Parse()
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", name)
+		}
+		usage()
+		if name == "help" {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitUsage)
+	}
 
-	worker := NewWorker(board.NewBoard(board.Point{X: *minX, Y: *minY}))
+	os.Exit(cmd.run(args))
+}
 
-	clients := readClients(logger, browser)
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	var wg sync.WaitGroup
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags], run a command with -help to list its flags\n\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+// loadConfig parses the flags of a command along with the settings of the config, and loads the config
+func loadConfig(flags *flag.FlagSet, args []string) (*config.Config, error) {
+	settings := config.RegisterFlags(flags)
+	flags.Parse(args)
+
+	return settings.Load()
+}
+
+// run logs every account in and draws the templates until it's killed
+func run(args []string) int {
+	cfg, err := loadConfig(flag.NewFlagSet("run", flag.ExitOnError), args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return exitUsage
+	}
+
+	templates, err := loadTemplates(cfg)
+	if err == nil {
+		err = checkBounds(templates)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid templates:", err)
+		return exitUsage
+	}
+
+	store, clients, err := readClients(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	logger := newLogger(cfg)
+	browser, err := client.NewBrowser()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	defer browser.Close()
+
+	b := board.NewBoard(templates...)
+	random := util.NewRand(time.Now().UnixNano())
+	worker := NewWorker(b, &LivePlacer{Board: b}, util.RealClock, random, settings(cfg))
+
+	setupClients(cfg, clients, logger, browser, b, random)
+
+	var saveLock sync.Mutex
+	save := func() {
+		saveLock.Lock()
+		defer saveLock.Unlock()
+
+		if err := store.Save(clients); err != nil {
+			logger.Error("Could not save the accounts", zap.Error(err))
+		}
+	}
+	go saveOnStateChange(worker.Bus(), save)
+	go logEvents(logger.Named("events"), worker.Bus())
+
+	placements := newPlacementLog(worker.Bus(), 500)
+
+	if cfg.Journal.Path != "" {
+		journal, err := OpenJournal(logger.Named("journal"), cfg.Journal.Path, int64(cfg.Journal.MaxSizeMB)<<20, cfg.Journal.Keep)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not open the journal:", err)
+			return exitFailure
+		}
+		defer journal.Close()
+
+		if err := restoreJournal(journal, worker, placements, clients); err != nil {
+			logger.Error("Could not replay the journal", zap.Error(err))
+		}
+		journal.Follow(worker.Bus())
+	}
+
+	var observer *client.Observer
+	if cfg.Observer.Enabled {
+		observer = client.NewObserver(logger.With(zap.String("username", "observer")), b, cfg.Observer.Token, dialOptions(cfg))
+		observer.Endpoints = endpoints(cfg)
+		go observer.Run()
+	}
+
+	if cfg.API.Enabled {
+		api := NewControlServer(logger.Named("api"), worker, b, clients, placements, cfg.API.Token)
+		if cfg.API.Token == "" {
+			logger.Warn("No token was given to the control API, using a random one", zap.String("token", api.Token))
+		}
+
+		go func() {
+			if err := api.ListenAndServe(cfg.API.Listen); err != nil {
+				logger.Error("The control API stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	if cfg.Dashboard.Enabled {
+		dashboard := NewDashboard(logger.Named("dashboard"), worker, b, clients, placements)
+		go func() {
+			if err := dashboard.ListenAndServe(cfg.Dashboard.Listen); err != nil {
+				logger.Error("The dashboard stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	if cfg.Metrics.Enabled {
+		registerMetrics(worker, b, clients)
+		go func() {
+			if err := serveMetrics(logger.Named("metrics"), cfg.Metrics.Listen); err != nil {
+				logger.Error("The metrics stopped", zap.Error(err))
+			}
+		}()
+	}
 
+	go worker.Run()
+	go NewControllerMonitor(worker, b, observer, util.RealClock, time.Duration(cfg.Scheduler.FrameTimeout)).Run()
+
+	var wg sync.WaitGroup
 	for _, c := range clients {
+		if status := c.CurrentStatus(); status.Dead() {
+			c.Warn("Skipping the account", zap.String("state", string(status.State)), zap.String("reason", status.Reason))
+			continue
+		}
+
 		wg.Add(1)
 		go func(c *client.Client) {
-			err := c.Login(&wg)
-			if err != nil {
-				clients = removeClient(clients, c)
+			defer wg.Done()
+
+			if err := c.Login(); err != nil {
+				c.Error("Login failed", zap.Error(err))
+				setStatus(worker.Bus(), c, client.StateLoginFailed, err.Error(), time.Now())
+				return
+			}
+
+			if c.CurrentStatus().Broken() {
+				setStatus(worker.Bus(), c, client.StateActive, "", time.Now())
 			}
+			worker.ClientJoin(c)
 		}(c)
 	}
 
-	fmt.Println("Waiting for wg to finish...")
+	logger.Info("Logging the accounts in", zap.Int("accounts", len(clients)))
 	wg.Wait()
-	fmt.Println("Login finished!")
+	logger.Info("Login finished", zap.Int("joined", len(worker.Clients())))
 
-	writeClients(clients...)
+	save()
 
-	worker.ClientJoin(clients...)
-	worker.Run()
+	go NewSessionRefresher(worker, clients, util.RealClock, time.Duration(cfg.Scheduler.RefreshMargin), save).Run()
+
+	select {}
 }
 
-func readClients(logger *zap.Logger, browser *client.Browser) (clients []*client.Client) {
-	file, err := os.Open("data/users.json")
+// readClients opens the account store of the config and loads its accounts
+func readClients(cfg *config.Config) (client.AccountStore, []*client.Client, error) {
+	store, err := openStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clients, err := store.Load()
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			os.Create("data/users.json")
-			panic("Please add users to the users.json file")
+			return store, nil, fmt.Errorf("there is no %s, add accounts with the accounts add or accounts migrate command: %w", cfg.Accounts, err)
 		}
-		panic(err)
+		return nil, nil, err
+	}
+
+	if len(clients) == 0 {
+		return store, nil, fmt.Errorf("no accounts found in %s", cfg.Accounts)
 	}
 
-	defer file.Close()
+	return store, clients, nil
+}
+
+// openStore opens the encrypted account store, the passphrase comes from PLACEBOT_PASSPHRASE or is asked on the terminal
+func openStore(cfg *config.Config) (*client.EncryptedStore, error) {
+	if passphrase, ok := os.LookupEnv("PLACEBOT_PASSPHRASE"); ok {
+		return client.NewEncryptedStore(cfg.Accounts, []byte(passphrase)), nil
+	}
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&clients)
+	passphrase, err := prompt(fmt.Sprintf("Passphrase of %s: ", cfg.Accounts))
 	if err != nil {
-		panic(fmt.Errorf("I could not decode the users.json file: %v", err))
+		return nil, err
 	}
 
-	config := &websocket.DialOptions{
-		HTTPHeader: http.Header{},
+	if _, err = os.Stat(cfg.Accounts); errors.Is(err, os.ErrNotExist) { // A typo would lock the new store
+		confirm, err := prompt("Confirm the passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if confirm != passphrase {
+			return nil, errors.New("the passphrases don't match")
+		}
 	}
 
-	config.HTTPHeader.Add("Accept-Encoding", "gzip, deflate, br")
-	config.HTTPHeader.Add("Accept-Language", "en-GB,en-US;q=0.9,en;q=0.8")
-	config.HTTPHeader.Add("Cache-Control", "no-cache")
-	config.HTTPHeader.Add("Pragma", "no-cache")
-	config.HTTPHeader.Add("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits")
-	config.HTTPHeader.Add("Sec-WebSocket-Key", "ito9k+J7oZkTKA3y7IS/Zw==")
-	config.HTTPHeader.Add("Sec-WebSocket-Version", "13")
-	config.HTTPHeader.Add("Upgrade", "websocket")
-	config.HTTPHeader.Add("Origin", "https://garlic-bread.reddit.com")
-	config.HTTPHeader.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36 OPR/100.0.0.0 (Edition std-2)")
+	if passphrase == "" {
+		return nil, errors.New("the passphrase is empty")
+	}
 
-	if len(clients) == 0 {
-		panic("No accounts found in data/users.json")
+	return client.NewEncryptedStore(cfg.Accounts, []byte(passphrase)), nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// prompt asks a line on the terminal
+func prompt(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(os.Stderr)
+		return "", fmt.Errorf("nothing was given: %w", err)
 	}
 
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// setupClients gives the clients what they need to log in and place
+func setupClients(cfg *config.Config, clients []*client.Client, logger *zap.Logger, browser *client.Browser, b *board.Board, random *util.Rand) {
+	options, servers := dialOptions(cfg), endpoints(cfg)
 	for _, client := range clients {
 		client.Logger = logger.With(zap.String("username", client.Username))
 		client.Browser = browser
-		client.WSconfig = config
-		client.AssignedData = util.NewCircularQueue[util.Pair[board2.Point, board2.Color]](0) // dynamic
+		client.Board = b
+		client.Clock = util.RealClock
+		client.Rand = random
+		client.WSconfig = options
+		client.Endpoints = servers
+	}
+}
+
+// dialOptions returns the headers of the browser, the websocket refuses connections without them
+func dialOptions(cfg *config.Config) *websocket.DialOptions {
+	options := &websocket.DialOptions{
+		HTTPHeader: http.Header{},
+	}
+
+	for key, value := range cfg.Headers {
+		options.HTTPHeader.Add(key, value)
 	}
 
-	return
+	return options
 }
 
-func writeClients(clients ...*client2.Client) {
-	file, err := os.Create("data/users.json")
-	if err != nil {
-		panic(err)
+func endpoints(cfg *config.Config) *client.Endpoints {
+	return &client.Endpoints{
+		Socket:    cfg.Endpoints.Socket,
+		Query:     cfg.Endpoints.Query,
+		Origin:    cfg.Endpoints.Origin,
+		TeamOwner: cfg.Endpoints.TeamOwner,
+		Proxy:     cfg.ProxyAddress(),
 	}
+}
 
-	defer file.Close()
+func settings(cfg *config.Config) Settings {
+	return Settings{
+		LeaseTTL:      time.Duration(cfg.Scheduler.LeaseTTL),
+		Recent:        time.Duration(cfg.Scheduler.Recent),
+		VerifyTimeout: time.Duration(cfg.Scheduler.VerifyTimeout),
+	}
+}
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(clients)
+// loadTemplates returns the templates of the config, their strategies are checked before anything runs
+func loadTemplates(cfg *config.Config) ([]*board.Template, error) {
+	specs, err := cfg.LoadTemplates()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-}
 
-var s sync.Mutex
+	templates := make([]*board.Template, len(specs))
+	for i, t := range specs {
+		if _, err = NewStrategy(t.Strategy, nil); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name, err)
+		}
+
+		templates[i] = board.NewTemplate(t.Name, t.Image, board.Point{X: t.X, Y: t.Y}, t.Strategy)
+	}
 
-func removeClient(clients []*client2.Client, client *client2.Client) []*client2.Client {
-	s.Lock()
-	defer s.Unlock()
+	return templates, nil
+}
 
-	for i, c := range clients {
-		if c == client {
-			clients[i] = clients[len(clients)-1]
-			return clients[:len(clients)-1]
+// checkBounds fails when a template goes off the canvas, the board can't place or follow those pixels
+func checkBounds(templates []*board.Template) error {
+	for _, t := range templates {
+		if err := t.CheckBounds(); err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
 		}
 	}
 
-	return clients
+	return nil
+}
+
+func newLogger(cfg *config.Config) *zap.Logger {
+	zapConfig := zap.NewProductionConfig()
+	if cfg.Log.Development {
+		zapConfig = zap.NewDevelopmentConfig()
+	}
+
+	level, _ := zap.ParseAtomicLevel(cfg.Log.Level) // Validated with the config
+	zapConfig.Level = level
+
+	logger, err := zapConfig.Build()
+	if err != nil {
+		panic(err)
+	}
+
+	return logger
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/metrics"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+var (
+	dispatched = metrics.NewCounter("placebot_worker_dispatched_total", "Pixels given to the clients by the worker.")
+	wakeups    = metrics.NewCounter("placebot_worker_wakeups_total", "Times the worker woke up, by reason.", "reason")
+)
+
+// registerMetrics adds the gauges read from the running bot when the metrics are scraped
+func registerMetrics(k *Worker, b *board.Board, accounts []*client.Client) {
+	metrics.NewGaugeFunc("placebot_template_pixels", "Pixels of the templates.", []string{"template"}, func(set func(float64, ...string)) {
+		for _, p := range b.Progress() {
+			set(float64(p.Total), p.Template.Name)
+		}
+	})
+
+	metrics.NewGaugeFunc("placebot_template_mismatched_pixels", "Pixels of the templates that don't match the canvas.", []string{"template"}, func(set func(float64, ...string)) {
+		for _, p := range b.Progress() {
+			set(float64(p.Mismatched), p.Template.Name)
+		}
+	})
+
+	metrics.NewGaugeFunc("placebot_queue_pixels", "Mismatched pixels of the enabled templates waiting for a client.", nil, func(set func(float64, ...string)) {
+		set(float64(k.QueueLength()))
+	})
+
+	metrics.NewGaugeFunc("placebot_accounts", "Accounts by state.", []string{"state"}, func(set func(float64, ...string)) {
+		counts := make(map[client.State]int)
+		for _, c := range accounts {
+			counts[c.CurrentStatus().At(time.Now())]++
+		}
+
+		for _, state := range client.States {
+			set(float64(counts[state]), string(state))
+		}
+	})
+
+	metrics.NewGaugeFunc("placebot_accounts_joined", "Accounts placing pixels in the worker.", nil, func(set func(float64, ...string)) {
+		set(float64(len(k.Clients())))
+	})
+
+	metrics.NewGaugeFunc("placebot_last_frame_timestamp_seconds", "When the board last received a canvas frame.", nil, func(set func(float64, ...string)) {
+		if last := b.LastFrame(); !last.IsZero() {
+			set(float64(last.UnixNano()) / 1e9)
+		}
+	})
+}
+
+// serveMetrics serves the metrics on the address until it fails
+func serveMetrics(logger *zap.Logger, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+
+	logger.Info("Serving the metrics", zap.String("address", "http://"+addr+"/metrics"))
+	return http.ListenAndServe(addr, mux)
+}
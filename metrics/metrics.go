@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// Default is the registry the metrics are added to by the constructors of this package
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// register adds a metric to the registry, it panics when the name is taken since it's a programming error
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[m.name()] {
+		panic(fmt.Sprintf("metric %s is registered twice", m.name()))
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics to Prometheus
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// desc is what every metric has, the values of the labels are joined to key the series
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, strings.ReplaceAll(d.help, "\n", " "), d.metricName, d.kind)
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", d.metricName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// series writes the labels of a key, with an extra label for the histogram buckets
+func (d *desc) series(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+`="`+escape.Replace(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape.Replace(extra[i+1])+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var escape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter only goes up, like the number of placements
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter returns a counter registered to the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}, values: make(map[string]float64)}
+	Default.register(c)
+	return c
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.series(key), formatFloat(c.values[key]))
+	}
+}
+
+// GaugeFunc is a value read when the metrics are scraped, like the number of banned accounts
+type GaugeFunc struct {
+	desc
+	collect func(set func(v float64, values ...string))
+}
+
+// NewGaugeFunc returns a gauge registered to the default registry, collect sets the value of every series
+func NewGaugeFunc(name, help string, labels []string, collect func(set func(v float64, values ...string))) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name, help, "gauge", labels}, collect: collect}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	values := make(map[string]float64)
+	g.collect(func(v float64, labels ...string) {
+		values[g.key(labels)] = v
+	})
+
+	g.header(w)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, g.series(key), formatFloat(values[key]))
+	}
+}
+
+// Histogram counts observations in buckets, like the duration of the requests
+type Histogram struct {
+	desc
+	buckets []float64 // Upper bounds, sorted
+	mu      sync.Mutex
+	data    map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // Not cumulative, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+// DefaultBuckets fits the duration in seconds of requests to the servers of reddit
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// NewHistogram returns a histogram registered to the default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{desc: desc{name, help, "histogram", labels}, buckets: buckets, data: make(map[string]*histogramSeries)}
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.data[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.data[key] = s
+	}
+
+	i := sort.SearchFloat64s(h.buckets, v) // The first bucket with an upper bound >= v
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	for _, key := range sortedKeys(h.data) {
+		s := h.data[key]
+
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+
+			bound := math.Inf(1)
+			if i < len(h.buckets) {
+				bound = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.series(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.series(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.series(key), s.count)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/events"
+	"sync"
+)
+
+// AccountStats is what an account did, restored from the journal after a restart
+type AccountStats struct {
+	Leased      int `json:"leased"`
+	Placed      int `json:"placed"`
+	Failed      int `json:"failed"` // Placements the server refused or never answered
+	Confirmed   int `json:"confirmed"`
+	Overwritten int `json:"overwritten"`
+	NeverLanded int `json:"neverLanded"`
+	Unverified  int `json:"unverified"` // The pixel history could not be fetched
+}
+
+// placementLog keeps the last placements published on the bus, the oldest are overwritten, the last placement and the stats of every account
+type placementLog struct {
+	mu       sync.Mutex
+	entries  []events.PixelPlaced
+	next     int
+	full     bool
+	accounts map[string]events.PixelPlaced
+	stats    map[string]*AccountStats
+}
+
+// newPlacementLog follows the placements of the bus and keeps the last size of them
+func newPlacementLog(bus *events.Bus, size int) *placementLog {
+	l := &placementLog{entries: make([]events.PixelPlaced, size), accounts: make(map[string]events.PixelPlaced), stats: make(map[string]*AccountStats)}
+
+	all := events.Subscribe[events.Event](bus, "placements", 256)
+	go func() {
+		for e := range all.C {
+			l.Apply(e)
+		}
+	}()
+
+	return l
+}
+
+// Apply counts a lease, a placement or a verification, the other events are ignored
+func (l *placementLog) Apply(e events.Event) {
+	switch e := e.(type) {
+	case events.PixelLeased:
+		l.account(e.Username, func(s *AccountStats) { s.Leased++ })
+	case events.PixelPlaced:
+		l.add(e)
+	case events.PixelVerified:
+		l.account(e.Username, func(s *AccountStats) {
+			switch e.Outcome {
+			case Confirmed.String():
+				s.Confirmed++
+			case OverwrittenAfter.String():
+				s.Overwritten++
+			case NeverLanded.String():
+				s.NeverLanded++
+			case Unverified.String():
+				s.Unverified++
+			}
+		})
+	}
+}
+
+func (l *placementLog) account(username string, fn func(s *AccountStats)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.stats[username]
+	if !ok {
+		s = &AccountStats{}
+		l.stats[username] = s
+	}
+	fn(s)
+}
+
+func (l *placementLog) add(e events.PixelPlaced) {
+	l.account(e.Username, func(s *AccountStats) {
+		if e.Outcome == "placed" {
+			s.Placed++
+		} else {
+			s.Failed++
+		}
+	})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = e
+	l.accounts[e.Username] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Last returns up to n placements, the most recent first
+func (l *placementLog) Last(n int) []events.PixelPlaced {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := l.next
+	if l.full {
+		size = len(l.entries)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	last := make([]events.PixelPlaced, n)
+	for i := range last {
+		last[i] = l.entries[(l.next-1-i+len(l.entries))%len(l.entries)]
+	}
+
+	return last
+}
+
+// Account returns the last placement of the account
+func (l *placementLog) Account(username string) (events.PixelPlaced, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.accounts[username]
+	return e, ok
+}
+
+// Stats returns what the account did
+func (l *placementLog) Stats(username string) AccountStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s, ok := l.stats[username]; ok {
+		return *s
+	}
+	return AccountStats{}
+}
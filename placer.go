@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/web"
+)
+
+// Placer talks to the server on behalf of the clients
+type Placer interface {
+	Place(c *client.Client, at board.Point, color board.Color) client.PlaceResult
+	GetCooldown(c *client.Client) client.PlaceResult
+	GetPlaceHistory(c *client.Client, at board.Point) (web.LastModified, error)
+}
+
+// LivePlacer sends the requests of the clients to r/place
+type LivePlacer struct {
+	Board *board.Board
+}
+
+func (p *LivePlacer) Place(c *client.Client, at board.Point, color board.Color) client.PlaceResult {
+	return c.Place(p.Board, at, color)
+}
+
+func (p *LivePlacer) GetCooldown(c *client.Client) client.PlaceResult {
+	return c.GetCooldown()
+}
+
+func (p *LivePlacer) GetPlaceHistory(c *client.Client, at board.Point) (web.LastModified, error) {
+	canvas, ok := p.Board.GetCanvasIndex(at)
+	if !ok {
+		return web.LastModified{}, fmt.Errorf("%w: %v", board.ErrOffCanvas, at)
+	}
+
+	return c.GetPlaceHistory(at, canvas)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// preview renders the templates quantized to the palette, at their place on the canvas, so the result can be checked before drawing
+func preview(args []string) int {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	output := flags.String("o", "preview.png", "Output PNG file")
+	scale := flags.Int("scale", 4, "Size of a canvas pixel in the PNG")
+	colors := flags.String("palette", "", "Comma separated indexes of the colors of the canvas, every color if empty")
+
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return exitUsage
+	}
+
+	if *scale < 1 {
+		fmt.Fprintln(os.Stderr, "The scale must be at least 1")
+		return exitUsage
+	}
+
+	indexes, err := parsePalette(*colors)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	board.SetActiveColors(board.Palette(indexes...))
+
+	templates, err := loadTemplates(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid templates:", err)
+		return exitUsage
+	}
+
+	for _, t := range templates {
+		if err = t.Read(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", t.Name, err)
+			return exitFailure
+		}
+
+		used := make(map[board.Color]struct{})
+		for _, c := range t.Image.Colors {
+			used[c] = struct{}{}
+		}
+		fmt.Fprintf(os.Stderr, "%s: %dx%d at %v, %d pixels, %d colors\n", t.Name, t.Image.Width, t.Image.Height, t.Origin, len(t.Image.Colors), len(used))
+	}
+
+	min, max := templates[0].Origin, templates[0].Origin
+	for _, t := range templates {
+		for point := range t.Image.Colors {
+			min.X, min.Y = minInt(min.X, point.X), minInt(min.Y, point.Y)
+			max.X, max.Y = maxInt(max.X, point.X+1), maxInt(max.Y, point.Y+1)
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, (max.X-min.X)**scale, (max.Y-min.Y)**scale))
+	for _, t := range templates { // The last template wins where they overlap, like on the board
+		for point, c := range t.Image.Colors {
+			x, y := (point.X-min.X)**scale, (point.Y-min.Y)**scale
+			for dx := 0; dx < *scale; dx++ {
+				for dy := 0; dy < *scale; dy++ {
+					img.SetNRGBA(x+dx, y+dy, color.NRGBA{R: c.R, G: c.G, B: c.B, A: 255})
+				}
+			}
+		}
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	defer file.Close()
+
+	if err = png.Encode(file, img); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+func parsePalette(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var indexes []int
+	for _, field := range strings.Split(s, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || index < 0 || index >= len(board.Colors) {
+			return nil, fmt.Errorf("invalid color index %q, expected 0 to %d", field, len(board.Colors)-1)
+		}
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"time"
+)
+
+// SessionRefresher gets new tokens for the clients before theirs expire, the worker keeps placing meanwhile,
+// and logs the accounts that lost their session in again so they can join the worker back
+type SessionRefresher struct {
+	worker  *Worker
+	clients []*client.Client // Every account, not only the ones in the worker
+	clock   util.Clock
+	margin  time.Duration // How long before the expiry a token is refreshed
+	retry   time.Duration // How long to wait after a failed refresh
+	failed  map[*client.Client]time.Time
+	save    func() // Persists the new tokens
+}
+
+func NewSessionRefresher(k *Worker, clients []*client.Client, clock util.Clock, margin time.Duration, save func()) *SessionRefresher {
+	return &SessionRefresher{
+		worker:  k,
+		clients: clients,
+		clock:   clock,
+		margin:  margin,
+		retry:   10 * time.Minute,
+		failed:  make(map[*client.Client]time.Time),
+		save:    save,
+	}
+}
+
+func (r *SessionRefresher) Run() {
+	ticker := r.clock.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		r.check()
+	}
+}
+
+// check refreshes the clients one at a time, they share the browser
+func (r *SessionRefresher) check() {
+	members := make(map[*client.Client]bool)
+	for _, c := range r.worker.Clients() {
+		members[c] = true
+	}
+
+	changed := false
+
+	for _, c := range r.clients {
+		now := r.clock.Now()
+		status := c.CurrentStatus()
+
+		if status.Dead() || now.Before(r.failed[c].Add(r.retry)) {
+			continue
+		}
+
+		switch {
+		case members[c] && c.NeedsRefresh(now, r.margin):
+			if err := r.refresh(c); err != nil {
+				c.Warn("Could not refresh the session", zap.Time("expiry", c.Expiry()), zap.Error(err))
+				r.failed[c] = now
+				continue
+			}
+
+			c.Info("Session refreshed", zap.Time("expiry", c.Expiry()))
+		case !members[c] && status.Broken():
+			if err := r.revive(c); err != nil {
+				c.Warn("Could not log the account in again", zap.Error(err))
+				setStatus(r.worker.Bus(), c, client.StateLoginFailed, err.Error(), now)
+				r.failed[c] = now
+				changed = true
+				continue
+			}
+
+			setStatus(r.worker.Bus(), c, client.StateActive, "", now)
+			r.worker.ClientJoin(c)
+		default:
+			continue
+		}
+
+		delete(r.failed, c)
+		changed = true
+	}
+
+	if changed && r.save != nil {
+		r.save()
+	}
+}
+
+// revive gets a new session for an account that lost its own, and follows the canvas with it
+func (r *SessionRefresher) revive(c *client.Client) error {
+	if err := r.refresh(c); err != nil {
+		return err
+	}
+
+	return c.Login() // The token is fresh, this only sets the client up
+}
+
+// refresh keeps a panic of the browser from taking the worker down
+func (r *SessionRefresher) refresh(c *client.Client) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("browser: %v", p)
+		}
+	}()
+
+	return c.Refresh()
+}
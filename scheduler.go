@@ -0,0 +1,154 @@
+package main
+
+import (
+	"container/heap"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"time"
+)
+
+type readyClient struct {
+	client *client.Client
+	next   time.Time
+	index  int
+}
+
+// clientHeap orders the clients by the moment they can place again
+type clientHeap []*readyClient
+
+func (h clientHeap) Len() int           { return len(h) }
+func (h clientHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+
+func (h clientHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *clientHeap) Push(x any) {
+	r := x.(*readyClient)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+
+func (h *clientHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	r.index = -1
+	*h = old[:len(old)-1]
+	return r
+}
+
+// Peek returns the client that can place first
+func (h clientHeap) Peek() *readyClient {
+	if len(h) == 0 {
+		return nil
+	}
+	return h[0]
+}
+
+// Remove takes the client out of the heap, if it's in it
+func (h *clientHeap) Remove(c *client.Client) {
+	for _, r := range *h {
+		if r.client == c {
+			heap.Remove(h, r.index)
+			return
+		}
+	}
+}
+
+// Pixel is a pixel of a template that doesn't match the canvas
+type Pixel struct {
+	At       board.Point
+	Color    board.Color
+	Template *board.Template
+	Damaged  time.Time // When we first saw it mismatched
+}
+
+// workQueue holds the mismatched pixels of every template, the templates take turns and their strategy picks the pixel
+type workQueue struct {
+	templates []*board.Template
+	pixels    map[*board.Template]map[board.Point]Pixel
+	damaged   map[board.Point]time.Time
+	disabled  map[*board.Template]bool // Templates the clients don't draw, their pixels stay in the queue
+	turn      int
+}
+
+func newWorkQueue() *workQueue {
+	return &workQueue{
+		pixels:   make(map[*board.Template]map[board.Point]Pixel),
+		damaged:  make(map[board.Point]time.Time),
+		disabled: make(map[*board.Template]bool),
+	}
+}
+
+// Reset replaces the queue with the given mismatched pixels, pixels already mismatched keep their damage time
+func (q *workQueue) Reset(b *board.Board, mismatched map[board.Point]board.Color, now time.Time) {
+	q.templates = b.Templates()
+	q.pixels = make(map[*board.Template]map[board.Point]Pixel, len(q.templates))
+
+	damaged := make(map[board.Point]time.Time, len(mismatched))
+	for at, color := range mismatched {
+		if t, ok := q.damaged[at]; ok {
+			damaged[at] = t
+		} else {
+			damaged[at] = now
+		}
+
+		q.Push(Pixel{At: at, Color: color, Template: b.TemplateOf(at), Damaged: damaged[at]})
+	}
+	q.damaged = damaged
+}
+
+func (q *workQueue) Push(p Pixel) {
+	if p.Template == nil {
+		return
+	}
+
+	if p.Damaged.IsZero() {
+		p.Damaged = q.damaged[p.At]
+	}
+
+	pixels, ok := q.pixels[p.Template]
+	if !ok {
+		pixels = make(map[board.Point]Pixel)
+		q.pixels[p.Template] = pixels
+	}
+	pixels[p.At] = p
+}
+
+// Pop takes the pixel the strategy of the next template picks for the client
+func (q *workQueue) Pop(c *client.Client, strategy func(t *board.Template) Strategy) (Pixel, bool) {
+	for i := 0; i < len(q.templates); i++ {
+		q.turn = (q.turn + 1) % len(q.templates)
+		t := q.templates[q.turn]
+		if q.disabled[t] {
+			continue
+		}
+
+		pixels := q.pixels[t]
+		if len(pixels) == 0 {
+			continue
+		}
+
+		p, ok := pixels[strategy(t).Next(c, t, pixels)]
+		if !ok { // The strategy picked a pixel that isn't mismatched, the work still goes on
+			p = pixels[pick(pixels, scanline)]
+		}
+		delete(pixels, p.At)
+		return p, true
+	}
+
+	return Pixel{}, false
+}
+
+// Len counts the pixels of the enabled templates
+func (q *workQueue) Len() (n int) {
+	for t, pixels := range q.pixels {
+		if !q.disabled[t] {
+			n += len(pixels)
+		}
+	}
+	return
+}
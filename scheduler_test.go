@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"testing"
+)
+
+// strayStrategy picks a pixel that is never among the mismatched ones
+type strayStrategy struct{}
+
+func (strayStrategy) Next(*client.Client, *board.Template, map[board.Point]Pixel) board.Point {
+	return board.Point{X: -1, Y: -1}
+}
+
+func TestWorkQueuePopStrayStrategy(t *testing.T) {
+	template := board.NewTemplate("test", "", board.Point{}, "")
+	q := newWorkQueue()
+	q.templates = []*board.Template{template}
+	q.Push(Pixel{At: board.Point{X: 1}, Color: board.Colors[31], Template: template})
+	q.Push(Pixel{At: board.Point{}, Color: board.Colors[31], Template: template})
+
+	for _, want := range []board.Point{{}, {X: 1}} {
+		p, ok := q.Pop(nil, func(*board.Template) Strategy { return strayStrategy{} })
+		if !ok || p.At != want || p.Template != template {
+			t.Errorf("Pop = %v, %v, want the pixel at %v of the template", p, ok, want)
+		}
+	}
+
+	if _, ok := q.Pop(nil, func(*board.Template) Strategy { return strayStrategy{} }); ok {
+		t.Error("Pop gave a pixel from an empty queue")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/util"
+	"github.com/Edouard127/redditplacebot/web"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// Simulator replaces r/place with an in-memory canvas, it controls the board and places the pixels of the clients
+type Simulator struct {
+	*zap.Logger
+	mu       sync.Mutex
+	board    *board.Board
+	clock    util.Clock
+	rand     *util.Rand
+	cooldown time.Duration
+	next     map[*client.Client]time.Time
+	history  map[board.Point]web.LastModified
+	placed   int
+	griefed  int
+}
+
+func NewSimulator(logger *zap.Logger, b *board.Board, clock util.Clock, cooldown time.Duration, rand *util.Rand) *Simulator {
+	return &Simulator{
+		Logger:   logger,
+		board:    b,
+		clock:    clock,
+		rand:     rand,
+		cooldown: cooldown,
+		next:     make(map[*client.Client]time.Time),
+		history:  make(map[board.Point]web.LastModified),
+	}
+}
+
+// Start takes control of the board, loads the templates and fills the canvas with white
+func (s *Simulator) Start() {
+	s.board.SetController(s)
+	s.board.SetColors(s, board.Palette())
+
+	blank := make(map[board.Point]board.Color, len(s.board.RequiredData.Colors))
+	for point := range s.board.RequiredData.Colors {
+		blank[point] = board.Colors[31]
+	}
+	s.board.SetPixels(s, blank)
+}
+
+func (s *Simulator) Place(c *client.Client, at board.Point, color board.Color) client.PlaceResult {
+	s.mu.Lock()
+	now := s.clock.Now()
+
+	if next := s.next[c]; next.After(now) {
+		s.mu.Unlock()
+		return client.PlaceResult{Point: at, Color: color, Kind: client.RateLimited, NextAvailable: next, Err: fmt.Errorf("Ratelimited")}
+	}
+
+	next := now.Add(s.cooldown).Add(time.Duration(s.rand.Intn(60)) * time.Second)
+	s.next[c] = next
+	s.history[at] = web.LastModified{LastModified: float64(now.UnixMilli()), UserInfo: web.UserInfo{Username: c.Username}}
+	s.placed++
+	s.mu.Unlock()
+
+	s.board.SetPixels(s, map[board.Point]board.Color{at: color})
+
+	return client.PlaceResult{Point: at, Color: color, Placed: true, PlacedAt: now, NextAvailable: next}
+}
+
+func (s *Simulator) GetCooldown(c *client.Client) client.PlaceResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return client.PlaceResult{NextAvailable: s.next[c]}
+}
+
+func (s *Simulator) GetPlaceHistory(_ *client.Client, at board.Point) (web.LastModified, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, ok := s.history[at]
+	if !ok {
+		return web.LastModified{}, fmt.Errorf("no history for %v", at)
+	}
+
+	return last, nil
+}
+
+// Grief paints a random pixel of the templates with a random color, like an attacker would
+func (s *Simulator) Grief() {
+	s.mu.Lock()
+	points := make([]board.Point, 0, len(s.board.RequiredData.Colors))
+	for point := range s.board.RequiredData.Colors {
+		points = append(points, point)
+	}
+	if len(points) == 0 {
+		s.mu.Unlock()
+		return
+	}
+
+	at := points[s.rand.Intn(len(points))]
+	color := board.Colors[s.rand.Intn(len(board.Colors))]
+	s.history[at] = web.LastModified{LastModified: float64(s.clock.Now().UnixMilli()), UserInfo: web.UserInfo{Username: "adversary"}}
+	s.griefed++
+	s.mu.Unlock()
+
+	s.board.SetPixels(s, map[board.Point]board.Color{at: color})
+}
+
+// simulate runs the worker against the simulator on a fake clock, and prints the completion of the templates over time
+func simulate(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	minX, minY := flags.Int("minX", 0, "Min X"), flags.Int("minY", 0, "Min Y")
+	image := flags.String("image", "../data/image.bmp", "The BMP image to draw")
+	strategy := flags.String("strategy", "random", fmt.Sprintf("How the next pixel is picked, one of %v", StrategyNames()))
+	clients := flags.Int("clients", 10, "Number of simulated accounts")
+	cooldown := flags.Duration("cooldown", 5*time.Minute, "Cooldown between two placements of an account")
+	grief := flags.Float64("grief", 0, "Pixels griefed per minute by a simulated adversary")
+	duration := flags.Duration("duration", time.Hour, "Simulated time to run for")
+	report := flags.Duration("report", time.Minute, "Simulated time between two reports")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "Seed of the simulation")
+	verbose := flags.Bool("v", false, "Log what the clients do")
+	flags.Parse(args)
+
+	if _, err := NewStrategy(*strategy, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	logger := zap.NewNop()
+	if *verbose {
+		logger, _ = zap.NewDevelopment()
+	}
+
+	template := board.NewTemplate("image", *image, board.Point{X: *minX, Y: *minY}, *strategy)
+	if err := checkBounds([]*board.Template{template}); err != nil { // The board panics on a template it can't load
+		fmt.Fprintln(os.Stderr, "Invalid template:", err)
+		return exitUsage
+	}
+
+	clock := util.NewFakeClock(time.Now().Truncate(time.Second))
+	random := util.NewRand(*seed)
+	b := board.NewBoard(template)
+	b.Clock = clock
+
+	sim := NewSimulator(logger, b, clock, *cooldown, random)
+	sim.Start()
+
+	worker := NewWorker(b, sim, clock, random, DefaultSettings)
+
+	accounts := make([]*client.Client, *clients)
+	for i := range accounts {
+		username := fmt.Sprintf("simulated-%d", i)
+		accounts[i] = &client.Client{Logger: logger.With(zap.String("username", username)), Username: username, Board: b, Clock: clock, Rand: random}
+	}
+	worker.ClientJoin(accounts...)
+	worker.refresh()
+
+	total := len(b.RequiredData.Colors)
+	start := clock.Now()
+	end := start.Add(*duration)
+	nextReport := start
+	nextGrief := sim.nextGrief(start, *grief)
+
+	fmt.Println("elapsed,completion,placed,griefed")
+
+	for now := start; !now.After(end); now = clock.Now() {
+		select {
+		case <-worker.changes:
+			worker.refresh()
+		default:
+		}
+
+		jobs, next := worker.dispatchReady()
+		for _, j := range jobs {
+			worker.place(j.client, j.pixel)
+		}
+		if len(jobs) > 0 {
+			continue
+		}
+
+		if !now.Before(nextReport) {
+			completion := 1 - float64(len(b.GetDifferentData()))/float64(total)
+			fmt.Printf("%s,%.4f,%d,%d\n", now.Sub(start), completion, sim.placed, sim.griefed)
+			nextReport = nextReport.Add(*report)
+		}
+
+		if !nextGrief.IsZero() && !now.Before(nextGrief) {
+			sim.Grief()
+			nextGrief = sim.nextGrief(now, *grief)
+			continue
+		}
+
+		step := nextReport
+		if !next.IsZero() && next.Before(step) {
+			step = next
+		}
+		if !nextGrief.IsZero() && nextGrief.Before(step) {
+			step = nextGrief
+		}
+		if step.After(end) {
+			step = end.Add(time.Nanosecond)
+		}
+
+		clock.Advance(step.Sub(now))
+	}
+
+	outcomes := worker.verifier.Outcomes()
+	fmt.Fprintf(os.Stderr, "placed %d pixels, %d griefed, %d confirmed, %d overwritten after, %d never landed, %d unverified\n",
+		sim.placed, sim.griefed, outcomes[Confirmed], outcomes[OverwrittenAfter], outcomes[NeverLanded], outcomes[Unverified])
+
+	return exitOK
+}
+
+// nextGrief draws when the adversary strikes next, the zero time if there is no adversary
+func (s *Simulator) nextGrief(now time.Time, perMinute float64) time.Time {
+	if perMinute <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(time.Duration(s.rand.ExpFloat64() / perMinute * float64(time.Minute)))
+}
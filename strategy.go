@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/util"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Strategy picks the next pixel a client places among the mismatched pixels of a template
+type Strategy interface {
+	// Next is never called with an empty set of pixels
+	Next(c *client.Client, t *board.Template, pixels map[board.Point]Pixel) board.Point
+}
+
+var strategies = map[string]func(r *util.Rand) Strategy{
+	"random":   func(r *util.Rand) Strategy { return randomStrategy{rand: r} },
+	"scanline": func(*util.Rand) Strategy { return scanlineStrategy{} },
+	"spiral":   func(*util.Rand) Strategy { return spiralStrategy{} },
+	"edges":    func(*util.Rand) Strategy { return edgesStrategy{} },
+	"damaged":  func(*util.Rand) Strategy { return damagedStrategy{} },
+	"clustered": func(r *util.Rand) Strategy {
+		return &clusteredStrategy{rand: r, last: make(map[*client.Client]board.Point)}
+	},
+}
+
+func NewStrategy(name string, r *util.Rand) (Strategy, error) {
+	if name == "" {
+		name = "random"
+	}
+
+	strategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q, expected one of %v", name, StrategyNames())
+	}
+
+	return strategy(r), nil
+}
+
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// pick returns the pixel that comes first in the given order
+func pick(pixels map[board.Point]Pixel, less func(a, b Pixel) bool) board.Point {
+	var best *Pixel
+	for _, p := range pixels {
+		p := p
+		if best == nil || less(p, *best) {
+			best = &p
+		}
+	}
+
+	return best.At
+}
+
+// scanline orders the pixels from top to bottom, then left to right
+func scanline(a, b Pixel) bool {
+	if a.At.Y != b.At.Y {
+		return a.At.Y < b.At.Y
+	}
+	return a.At.X < b.At.X
+}
+
+type randomStrategy struct {
+	rand *util.Rand
+}
+
+func (s randomStrategy) Next(c *client.Client, t *board.Template, pixels map[board.Point]Pixel) board.Point {
+	return randomPixel(s.rand, pixels)
+}
+
+// randomPixel picks a pixel uniformly, map iteration order is not random enough for that
+func randomPixel(r *util.Rand, pixels map[board.Point]Pixel) board.Point {
+	n := r.Intn(len(pixels))
+	for at := range pixels {
+		if n == 0 {
+			return at
+		}
+		n--
+	}
+
+	panic("unreachable")
+}
+
+type scanlineStrategy struct{}
+
+func (scanlineStrategy) Next(_ *client.Client, _ *board.Template, pixels map[board.Point]Pixel) board.Point {
+	return pick(pixels, scanline)
+}
+
+// spiralStrategy builds from the center of the template to its borders
+type spiralStrategy struct{}
+
+func (spiralStrategy) Next(_ *client.Client, t *board.Template, pixels map[board.Point]Pixel) board.Point {
+	center := t.Center()
+
+	polar := func(p Pixel) (int, float64) {
+		dx, dy := p.At.X-center.X, p.At.Y-center.Y
+		return dx*dx + dy*dy, math.Atan2(float64(dy), float64(dx))
+	}
+
+	return pick(pixels, func(a, b Pixel) bool {
+		da, aa := polar(a)
+		db, ab := polar(b)
+		if da != db {
+			return da < db
+		}
+		return aa < ab
+	})
+}
+
+// edgesStrategy places the outline of the shapes first, so the art stays recognizable
+type edgesStrategy struct{}
+
+func (edgesStrategy) Next(_ *client.Client, t *board.Template, pixels map[board.Point]Pixel) board.Point {
+	return pick(pixels, func(a, b Pixel) bool {
+		ea, eb := t.Edge(a.At), t.Edge(b.At)
+		if ea != eb {
+			return ea
+		}
+		return scanline(a, b)
+	})
+}
+
+// damagedStrategy repairs the pixels that were damaged last first, to defend against an ongoing attack
+type damagedStrategy struct{}
+
+func (damagedStrategy) Next(_ *client.Client, _ *board.Template, pixels map[board.Point]Pixel) board.Point {
+	return pick(pixels, func(a, b Pixel) bool {
+		if !a.Damaged.Equal(b.Damaged) {
+			return a.Damaged.After(b.Damaged)
+		}
+		return scanline(a, b)
+	})
+}
+
+// clusteredStrategy keeps the pixels of a client close to each other
+type clusteredStrategy struct {
+	mu   sync.Mutex
+	rand *util.Rand
+	last map[*client.Client]board.Point
+}
+
+func (s *clusteredStrategy) Next(c *client.Client, _ *board.Template, pixels map[board.Point]Pixel) board.Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, ok := s.last[c]
+
+	var at board.Point
+	if !ok {
+		at = randomPixel(s.rand, pixels) // The clients start their clusters in different places
+	} else {
+		at = pick(pixels, func(a, b Pixel) bool {
+			da := (a.At.X-last.X)*(a.At.X-last.X) + (a.At.Y-last.Y)*(a.At.Y-last.Y)
+			db := (b.At.X-last.X)*(b.At.X-last.X) + (b.At.Y-last.Y)*(b.At.Y-last.Y)
+			if da != db {
+				return da < db
+			}
+			return scanline(a, b)
+		})
+	}
+
+	s.last[c] = at
+	return at
+}
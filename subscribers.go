@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/events"
+	"go.uber.org/zap"
+	"time"
+)
+
+// setStatus moves the client to the state and publishes the change, the cooldowns come from the placements instead
+func setStatus(bus *events.Bus, c *client.Client, state client.State, reason string, now time.Time) {
+	before := c.CurrentStatus().State
+	c.SetStatus(state, reason, now, time.Time{})
+
+	if before != state {
+		bus.Publish(events.ClientStateChanged{Time: now, Client: c, Username: c.Username, From: before, To: state, Reason: reason})
+	}
+}
+
+// saveOnStateChange persists the accounts every time one of them changes state
+func saveOnStateChange(bus *events.Bus, save func()) {
+	changes := events.Subscribe[events.ClientStateChanged](bus, "save", 64)
+	for range changes.C {
+		save()
+	}
+}
+
+// logEvents writes every event of the bus to the debug log
+func logEvents(logger *zap.Logger, bus *events.Bus) {
+	all := events.Subscribe[events.Event](bus, "log", 256)
+	for e := range all.C {
+		logger.Debug(e.Name(), zap.Any("event", e))
+	}
+}
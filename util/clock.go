@@ -0,0 +1,128 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time, so the timing logic can run on simulated time
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+// RealClock is the wall clock
+var RealClock Clock = realClock{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	ch     chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	delete(t.clock.tickers, t)
+}
+
+// FakeClock only moves forward when it is advanced
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+	tickers map[*fakeTicker]struct{}
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start, tickers: make(map[*fakeTicker]struct{})}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{clock: c, ch: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers[t] = struct{}{}
+	return t
+}
+
+// Advance moves the clock forward and fires every channel that is due, tickers drop the ticks nobody read like real ones
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+
+	n := 0
+	for n < len(c.waiters) && !c.waiters[n].at.After(c.now) {
+		c.waiters[n].ch <- c.waiters[n].at
+		n++
+	}
+	c.waiters = c.waiters[n:]
+
+	for t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.ch <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
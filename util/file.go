@@ -0,0 +1,36 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes the file through a temporary file renamed over it, a crash leaves either the old or the new content
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name()) // Fails once the file is renamed, which is fine
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
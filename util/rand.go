@@ -0,0 +1,37 @@
+package util
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Rand is a seeded source of randomness safe for concurrent use
+type Rand struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewRand(seed int64) *Rand {
+	return &Rand{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (r *Rand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rand.Intn(n)
+}
+
+func (r *Rand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rand.Float64()
+}
+
+func (r *Rand) ExpFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rand.ExpFloat64()
+}
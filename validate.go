@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"os"
+)
+
+// validate checks the configuration, the templates and the accounts without connecting to anything
+func validate(args []string) int {
+	cfg, err := loadConfig(flag.NewFlagSet("validate", flag.ExitOnError), args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return exitUsage
+	}
+
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	board.SetActiveColors(board.Palette())
+
+	templates, err := loadTemplates(cfg)
+	if err != nil {
+		report("templates: %v", err)
+	}
+
+	owners := make(map[board.Point]*board.Template)
+	for _, t := range templates {
+		if err = t.Read(); err != nil {
+			report("%s: %v", t.Name, err)
+			continue
+		}
+
+		off, overlap := 0, make(map[string]int)
+		for point := range t.Image.Colors {
+			if !board.InCanvas(point) {
+				off++
+			}
+			if other, ok := owners[point]; ok {
+				overlap[other.Name]++
+			}
+			owners[point] = t
+		}
+
+		if off > 0 {
+			report("%s: %d pixels are off the canvas", t.Name, off)
+		}
+		for other, n := range overlap {
+			report("%s: %d pixels overlap %s, %s wins", t.Name, n, other, t.Name)
+		}
+	}
+
+	_, clients, err := readClients(cfg)
+	if err != nil {
+		report("accounts: %v", err)
+	}
+
+	seen := make(map[string]bool, len(clients))
+	for i, c := range clients {
+		switch {
+		case c.Username == "":
+			report("accounts: account %d has no username", i)
+		case seen[c.Username]:
+			report("accounts: %s is there twice", c.Username)
+		case c.Password == "" && c.AccessToken == "" && c.Cookies == nil:
+			report("accounts: %s has no password and no session, it can't log in", c.Username)
+		}
+		seen[c.Username] = true
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+
+	if len(problems) > 0 {
+		return exitFailure
+	}
+
+	fmt.Printf("%d templates and %d accounts are valid\n", len(templates), len(clients))
+	return exitOK
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/events"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// Outcome is what happened to a placement once the verifier looked at it
+type Outcome int
+
+const (
+	Pending          Outcome = iota
+	Confirmed                // The pixel landed and is still ours
+	OverwrittenAfter         // The pixel landed but someone placed over it before we could see it
+	NeverLanded              // The server accepted the pixel but the canvas never had it
+	Unverified               // The pixel history could not be fetched, so nobody knows
+)
+
+// historyAttempts is how many times the pixel history is asked before a placement stays unverified,
+// historyBackoff is the wait before the second attempt, it doubles after every failure
+const (
+	historyAttempts = 3
+	historyBackoff  = 2 * time.Second
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Pending:
+		return "pending"
+	case Confirmed:
+		return "confirmed"
+	case OverwrittenAfter:
+		return "overwritten after"
+	case NeverLanded:
+		return "never landed"
+	case Unverified:
+		return "unverified"
+	}
+
+	return "unknown"
+}
+
+type Placement struct {
+	Client  *client.Client
+	Point   board.Point
+	Color   board.Color
+	At      time.Time
+	Outcome Outcome
+}
+
+// Verifier confirms placements against the canvas frames first, and falls back to the pixel history after a timeout
+type Verifier struct {
+	mu       sync.Mutex
+	board    *board.Board
+	placer   Placer
+	clock    util.Clock
+	timeout  time.Duration
+	pending  map[board.Point]*Placement
+	outcomes map[Outcome]int
+	requeue  func(at board.Point, color board.Color) // Called when a pixel never landed
+
+	Events *events.Bus // Where the outcomes are published, nil to keep them
+}
+
+func NewVerifier(b *board.Board, placer Placer, clock util.Clock, timeout time.Duration, requeue func(at board.Point, color board.Color)) *Verifier {
+	v := &Verifier{
+		board:    b,
+		placer:   placer,
+		clock:    clock,
+		timeout:  timeout,
+		pending:  make(map[board.Point]*Placement),
+		outcomes: make(map[Outcome]int),
+		requeue:  requeue,
+	}
+
+	b.Listen(v.onCanvas)
+
+	return v
+}
+
+// Track starts verifying a placement accepted by the server
+func (v *Verifier) Track(c *client.Client, result client.PlaceResult) {
+	p := &Placement{
+		Client: c,
+		Point:  result.Point,
+		Color:  result.Color,
+		At:     result.PlacedAt,
+	}
+
+	v.mu.Lock()
+	v.pending[p.Point] = p
+	if color, ok := v.board.CurrentColor(p.Point); ok && color == p.Color { // The frame came before the answer
+		v.resolve(p, Confirmed)
+	}
+	v.mu.Unlock()
+
+	timeout := v.clock.After(v.timeout)
+	go func() {
+		<-timeout
+		v.fallback(p)
+	}()
+}
+
+// Outcomes returns how many placements ended up with each outcome
+func (v *Verifier) Outcomes() map[Outcome]int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	outcomes := make(map[Outcome]int, len(v.outcomes))
+	for o, n := range v.outcomes {
+		outcomes[o] = n
+	}
+
+	return outcomes
+}
+
+func (v *Verifier) onCanvas(changed map[board.Point]board.Color) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for point, color := range changed {
+		if p, ok := v.pending[point]; ok && p.Color == color {
+			v.resolve(p, Confirmed)
+		}
+	}
+}
+
+// fallback asks the pixel history when the canvas did not confirm the placement in time
+func (v *Verifier) fallback(p *Placement) {
+	v.mu.Lock()
+	if v.pending[p.Point] != p {
+		v.mu.Unlock()
+		return
+	}
+	v.mu.Unlock()
+
+	outcome := Confirmed
+	if color, ok := v.board.CurrentColor(p.Point); !ok || color != p.Color {
+		outcome = v.history(p)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.pending[p.Point] == p {
+		v.resolve(p, outcome)
+	}
+}
+
+// history asks the pixel history who placed the pixel last, the request is tried again with a backoff when it fails,
+// a placement that the history can't tell about is unverified rather than never landed, so it's not placed twice
+func (v *Verifier) history(p *Placement) Outcome {
+	backoff := historyBackoff
+	for attempt := 1; ; attempt++ {
+		last, err := v.placer.GetPlaceHistory(p.Client, p.Point)
+		if err == nil {
+			switch {
+			case last.UserInfo.Username == p.Client.Username:
+				return Confirmed
+			case time.UnixMilli(int64(last.LastModified)).Before(p.At):
+				return NeverLanded
+			default:
+				return OverwrittenAfter
+			}
+		}
+
+		if attempt == historyAttempts {
+			p.Client.Logger.Warn("Could not fetch the pixel history, the placement stays unverified", zap.Any("point", p.Point), zap.Int("attempts", attempt), zap.Error(err))
+			return Unverified
+		}
+
+		p.Client.Logger.Debug("Could not fetch the pixel history, trying again", zap.Any("point", p.Point), zap.Duration("in", backoff), zap.Error(err))
+		<-v.clock.After(backoff)
+		backoff *= 2
+
+		v.mu.Lock()
+		resolved := v.pending[p.Point] != p // A frame confirmed it meanwhile
+		v.mu.Unlock()
+		if resolved {
+			return Pending
+		}
+	}
+}
+
+// resolve records the outcome of a placement, it must be called with the lock held
+func (v *Verifier) resolve(p *Placement, outcome Outcome) {
+	delete(v.pending, p.Point)
+	p.Outcome = outcome
+	v.outcomes[outcome]++
+
+	p.Client.Logger.Debug("Placement verified", zap.Any("point", p.Point), zap.Stringer("outcome", outcome))
+	v.Events.Publish(events.PixelVerified{Time: v.clock.Now(), Client: p.Client, Username: p.Client.Username, Point: p.Point, Color: p.Color, Outcome: outcome.String()})
+
+	if outcome == NeverLanded && v.requeue != nil {
+		go v.requeue(p.Point, p.Color)
+	}
+}
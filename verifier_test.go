@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/events"
+	"github.com/Edouard127/redditplacebot/util"
+	"github.com/Edouard127/redditplacebot/web"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVerifierTimeout(t *testing.T) {
+	white := board.Colors[31]
+	at := board.Point{}
+
+	tests := []struct {
+		name        string
+		frame       bool // The canvas shows the pixel before the timeout
+		history     web.LastModified
+		historyErr  error
+		want        Outcome
+		wantLookups int
+		wantRequeue bool
+	}{
+		{name: "confirmed by a frame", frame: true, want: Confirmed},
+		{name: "confirmed by the history", history: web.LastModified{LastModified: float64(testStart.UnixMilli()), UserInfo: web.UserInfo{Username: "client-0"}}, want: Confirmed, wantLookups: 1},
+		{name: "overwritten after", history: web.LastModified{LastModified: float64(testStart.Add(time.Second).UnixMilli()), UserInfo: web.UserInfo{Username: "someone"}}, want: OverwrittenAfter, wantLookups: 1},
+		{name: "never landed", history: web.LastModified{LastModified: float64(testStart.Add(-time.Minute).UnixMilli()), UserInfo: web.UserInfo{Username: "someone"}}, want: NeverLanded, wantLookups: 1, wantRequeue: true},
+		{name: "history unavailable", historyErr: errors.New("connection reset"), want: Unverified, wantLookups: historyAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := util.NewFakeClock(testStart)
+			b := testBoard(t, clock, 1, 1)
+			placer := newTestPlacer()
+			placer.history[at], placer.historyErr = tt.history, tt.historyErr
+
+			var requeued atomic.Bool
+			v := NewVerifier(b, placer, clock, DefaultSettings.VerifyTimeout, func(board.Point, board.Color) { requeued.Store(true) })
+			v.Events = events.NewBus()
+			verified := events.Subscribe[events.PixelVerified](v.Events, "test", 1)
+			defer verified.Close()
+
+			c := testClients(clock, 1)[0]
+			v.Track(c, client.PlaceResult{Point: at, Color: white, Placed: true, PlacedAt: testStart})
+
+			if tt.frame {
+				b.SetPixels(testController{}, map[board.Point]board.Color{at: white})
+			}
+
+			var got events.PixelVerified
+			for waited := 0; ; waited++ { // The fallback waits on the fake clock, so it's moved until the outcome comes
+				if waited == 1000 {
+					t.Fatal("the placement was never verified")
+				}
+
+				select {
+				case got = <-verified.C:
+				case <-time.After(time.Millisecond):
+					clock.Advance(time.Second)
+					continue
+				}
+				break
+			}
+
+			if got.Outcome != tt.want.String() {
+				t.Errorf("outcome = %s, want %s", got.Outcome, tt.want)
+			}
+			if outcomes := v.Outcomes(); outcomes[tt.want] != 1 {
+				t.Errorf("outcomes = %v, want one %s", outcomes, tt.want)
+			}
+
+			placer.mu.Lock()
+			lookups := placer.lookups
+			placer.mu.Unlock()
+			if lookups != tt.wantLookups {
+				t.Errorf("history asked %d times, want %d", lookups, tt.wantLookups)
+			}
+
+			time.Sleep(10 * time.Millisecond) // The requeue runs in its own goroutine
+			if requeued.Load() != tt.wantRequeue {
+				t.Errorf("requeued = %v, want %v", requeued.Load(), tt.wantRequeue)
+			}
+		})
+	}
+}
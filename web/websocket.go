@@ -1,6 +1,13 @@
 package web
 
-import "github.com/Edouard127/redditplacebot/board"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
 
 type Payload[T any] struct {
 	Id      string `json:"id,omitempty"`
@@ -37,6 +44,11 @@ type SubscribeReplace struct {
 	Tag       string `json:"tag"`
 }
 
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
 type Message struct {
 	Message string `json:"message"`
 }
@@ -78,8 +90,8 @@ type CanvasConfigurations struct {
 }
 
 type ActiveZone struct {
-	TopLeft     board.Point `json:"topLeft"`
-	BottomRight board.Point `json:"bottomRight"`
+	TopLeft     Point `json:"topLeft"`
+	BottomRight Point `json:"bottomRight"`
 }
 
 type CanvasUpdateData struct {
@@ -87,6 +99,7 @@ type CanvasUpdateData struct {
 }
 
 type CanvasInfo struct {
+	Typename          string  `json:"__typename"`
 	CurrentTimestamp  float64 `json:"currentTimestamp"`
 	Name              string  `json:"name"`
 	PreviousTimestamp float64 `json:"previousTimestamp"`
@@ -101,10 +114,14 @@ type PlaceInput[PlaceType any] struct {
 	PixelMessageData PlaceType `json:"PixelMessageData"`
 }
 
+type ActionInput struct {
+	ActionName string `json:"actionName"`
+}
+
 type PlaceData struct {
-	CanvasIndex int         `json:"canvasIndex"`
-	ColorIndex  int         `json:"colorIndex"`
-	Coordinate  board.Point `json:"coordinate"`
+	CanvasIndex int   `json:"canvasIndex"`
+	ColorIndex  int   `json:"colorIndex"`
+	Coordinate  Point `json:"coordinate"`
 }
 
 type Error struct {
@@ -117,13 +134,76 @@ type ErrorData struct {
 }
 
 type ErrorExtension struct {
-	NextAvailablePixelTimestamp any `json:"nextAvailablePixelTs"`
+	NextAvailablePixelTimestamp Cooldown `json:"nextAvailablePixelTs"`
+}
+
+// Cooldown is a unix timestamp in milliseconds, the server sends it either as a number or as a string
+type Cooldown int64
+
+func (c *Cooldown) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if len(data) == 0 || string(data) == "null" {
+		*c = 0
+		return nil
+	}
+
+	ms, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("invalid cooldown %q: %w", data, err)
+	}
+
+	*c = Cooldown(ms)
+	return nil
+}
+
+func (c Cooldown) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(c))
+}
+
+// Time returns the moment the cooldown ends, the zero time if there is none
+func (c Cooldown) Time() time.Time {
+	if c == 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(int64(c))
+}
+
+// Banned reports if the cooldown is the one given to banned accounts
+func (c Cooldown) Banned() bool {
+	return int64(c)/1000 == math.MaxInt32
+}
+
+type PlaceResponse struct {
+	Errors []ErrorData      `json:"errors"`
+	Data   PlaceActResponse `json:"data"`
+}
+
+type PlaceActResponse struct {
+	Act Act[[]PlaceResponseData] `json:"act"`
+}
+
+type PlaceResponseData struct {
+	Id   string      `json:"id"`
+	Data PixelResult `json:"data"`
+}
+
+// PixelResult is either a GetUserCooldownResponseMessageData or a SetPixelResponseMessageData
+type PixelResult struct {
+	Typename                    string   `json:"__typename"`
+	NextAvailablePixelTimestamp Cooldown `json:"nextAvailablePixelTimestamp"`
+	Timestamp                   float64  `json:"timestamp"`
 }
 
 type Act[D any] struct {
 	Data D `json:"data"`
 }
 
+type HistoryResponse struct {
+	Errors []ErrorData `json:"errors"`
+	Data   HistoryData `json:"data"`
+}
+
 type HistoryData struct {
 	Act Act[[]HistoryResponseData] `json:"act"`
 }
@@ -148,8 +228,8 @@ type Subscribe Payload[Var[VarInput[Input[SubscribeConfig]]]]
 type Replace Payload[Var[VarInput[Input[SubscribeReplace]]]]
 type Place Var[PlacePixel]
 type History Var[VarInput[PlaceInput[PlaceData]]]
+type UserCooldown Var[VarInput[ActionInput]]
 
 type ConnectionUnauthorized Payload[Message]
 type SubscribedData Payload[SubscribeResponse]
 type CanvasUpdate Payload[CanvasUpdateData]
-type HistoryResponse Act[HistoryData]
@@ -1,71 +1,512 @@
 package main
 
 import (
+	"container/heap"
 	"github.com/Edouard127/redditplacebot/board"
 	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/events"
+	"github.com/Edouard127/redditplacebot/util"
+	"go.uber.org/zap"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Settings tunes how the worker shares the pixels between the clients
+type Settings struct {
+	LeaseTTL      time.Duration // How long a client can hold a pixel before it goes back to the others
+	Recent        time.Duration // How long a placed pixel is left alone, so the canvas has time to show it
+	VerifyTimeout time.Duration // How long the canvas has to show a placement before the history is asked
+}
+
+var DefaultSettings = Settings{
+	LeaseTTL:      time.Minute,
+	Recent:        45 * time.Second,
+	VerifyTimeout: 30 * time.Second,
+}
+
 type Worker struct {
-	waitList map[*client.Client]time.Time
-	clients  []*client.Client
+	cooldowns  *client.Cooldowns
+	verifier   *Verifier
+	ledger     *Ledger
+	clients    []*client.Client
+	ready      clientHeap // Clients waiting for their cooldown, the ones placing a pixel are not in it
+	queue      *workQueue // Pixels that don't match the templates
+	strategies map[*board.Template]Strategy
+	paused     map[*client.Client]bool      // Clients kept out of the heap until they are resumed
+	restored   map[*client.Client]time.Time // Cooldowns of the journal for the clients that did not join yet
+	placing    map[*client.Client]bool      // Clients waiting for the answer of the server, they are not in the heap either
+	bus        *events.Bus
 
-	ticker     *time.Ticker
 	board      *board.Board
+	placer     Placer
+	clock      util.Clock
+	rand       *util.Rand
+	changes    chan struct{} // The canvas changed
+	wake       chan struct{} // The clients or the queue changed
+	primed     atomic.Bool   // The board got its first frame
 	clientLock sync.Mutex
 }
 
-func NewWorker(b *board.Board) (k *Worker) {
-	return &Worker{
-		waitList: make(map[*client.Client]time.Time, 0),
-		clients:  make([]*client.Client, 0),
-		ticker:   time.NewTicker(time.Second),
-		board:    b,
+func NewWorker(b *board.Board, placer Placer, clock util.Clock, rand *util.Rand, settings Settings) (k *Worker) {
+	k = &Worker{
+		cooldowns:  client.NewCooldowns(),
+		ledger:     NewLedger(settings.LeaseTTL, settings.Recent),
+		clients:    make([]*client.Client, 0),
+		queue:      newWorkQueue(),
+		strategies: make(map[*board.Template]Strategy),
+		paused:     make(map[*client.Client]bool),
+		restored:   make(map[*client.Client]time.Time),
+		placing:    make(map[*client.Client]bool),
+		bus:        events.NewBus(),
+		board:      b,
+		placer:     placer,
+		clock:      clock,
+		rand:       rand,
+		changes:    make(chan struct{}, 1),
+		wake:       make(chan struct{}, 1),
 	}
+	k.verifier = NewVerifier(b, placer, clock, settings.VerifyTimeout, k.requeue)
+	k.verifier.Events = k.bus
+
+	b.Listen(k.onCanvas)
+
+	return k
+}
+
+// ClientJoin adds clients to the running worker, their cooldown is fetched before they get a pixel
+func (k *Worker) ClientJoin(clients ...*client.Client) {
+	for _, c := range clients {
+		if status := c.CurrentStatus(); status.Dead() {
+			c.Logger.Warn("Account can't place anymore, not joining", zap.String("state", string(status.State)), zap.String("reason", status.Reason))
+			continue
+		}
+
+		k.board.SetController(c)
+
+		result := k.placer.GetCooldown(c)
+		if result.Kind == client.NoError {
+			c.Logger.Info("Cooldown fetched", zap.Time("next", result.NextAvailable))
+		}
+
+		k.clientLock.Lock()
+		joined := !k.member(c)
+		if joined {
+			k.clients = append(k.clients, c)
+			k.handle(c, result)
+
+			if next, ok := k.restored[c]; ok {
+				delete(k.restored, c)
+				if k.member(c) { // The cooldown of the server wins over the journal
+					k.restore(c, next, result.Kind == client.NoError)
+				}
+			}
+		}
+		k.clientLock.Unlock()
+
+		if joined {
+			k.bus.Publish(events.ClientChanged{Time: k.clock.Now(), Client: c, Username: c.Username, Change: "joined"})
+		}
+	}
+
+	notify(k.wake)
 }
 
-func (k *Worker) ClientJoin(client ...*client.Client) {
+// ClientLeave removes a client from the running worker, its leased pixels go back to the other clients
+func (k *Worker) ClientLeave(c *client.Client) {
+	k.clientLock.Lock()
+	k.leave(c)
+	k.clientLock.Unlock()
+
+	notify(k.wake)
+}
+
+// Clients returns the clients taking part in the worker
+func (k *Worker) Clients() []*client.Client {
 	k.clientLock.Lock()
 	defer k.clientLock.Unlock()
 
-	k.board.SetController(client[0])
+	return append([]*client.Client{}, k.clients...)
+}
 
-	k.clients = append(k.clients, client...)
+// member reports if the client takes part in the worker, it must be called with the client lock held
+func (k *Worker) member(c *client.Client) bool {
+	for _, cl := range k.clients {
+		if cl == c {
+			return true
+		}
+	}
+
+	return false
 }
 
+// Run dispatches one pixel to every client as soon as its cooldown ends, it sleeps until then or until the canvas changes
 func (k *Worker) Run() {
+	k.refresh()
+
 	for {
+		jobs, next := k.dispatchReady()
+		dispatched.Add(float64(len(jobs)))
+		for _, j := range jobs {
+			go k.place(j.client, j.pixel)
+		}
+
+		var wait <-chan time.Time
+		if !next.IsZero() {
+			wait = k.clock.After(next.Sub(k.clock.Now()))
+		}
+
 		select {
-		case <-k.ticker.C:
-			changed := k.board.GetDifferentData()
-			if len(changed) > 0 {
-				split := splitMap(changed, len(k.clients))
-				for i, c := range k.clients {
-					c.Assign(split[i])
-
-					if t, ok := k.waitList[c]; ok {
-						if !t.After(time.Now()) {
-							c.Logger.Info("Placing c after wait")
-							k.waitList[c] = c.Place(k.board)
-						}
-					} else {
-						c.Logger.Info("Placing c")
-						k.waitList[c] = c.Place(k.board)
-					}
+		case <-wait:
+			wakeups.Inc("cooldown")
+		case <-k.wake:
+			wakeups.Inc("wake")
+		case <-k.changes:
+			wakeups.Inc("canvas")
+			k.refresh()
+		}
+	}
+}
+
+type job struct {
+	client *client.Client
+	pixel  Pixel
+}
+
+// dispatchReady leases a pixel to every ready client, and returns when the next client is ready if there is still work
+func (k *Worker) dispatchReady() (jobs []job, next time.Time) {
+	k.clientLock.Lock()
+	defer k.clientLock.Unlock()
+
+	for k.queue.Len() > 0 {
+		ready := k.ready.Peek()
+		if ready == nil {
+			return jobs, time.Time{}
+		}
+
+		if ready.next.After(k.clock.Now()) {
+			return jobs, ready.next
+		}
+
+		p, ok := k.lease(ready.client)
+		if !ok {
+			return jobs, time.Time{}
+		}
+
+		heap.Pop(&k.ready)
+		k.placing[ready.client] = true
+		jobs = append(jobs, job{client: ready.client, pixel: p})
+
+		now := k.clock.Now()
+		k.bus.Publish(events.PixelLeased{Time: now, Client: ready.client, Username: ready.client.Username, Template: p.Template.Name, Point: p.At, Color: p.Color, Expires: now.Add(k.ledger.ttl)})
+	}
+
+	return jobs, time.Time{}
+}
+
+// lease takes the pixel picked by the strategies that is not leased or recently placed, and leases it to the client
+func (k *Worker) lease(c *client.Client) (Pixel, bool) {
+	for {
+		p, ok := k.queue.Pop(c, k.strategy)
+		if !ok {
+			return p, false
+		}
+
+		if k.ledger.Lease(c, p.At, p.Color, k.clock.Now()) {
+			return p, true
+		}
+	}
+}
+
+// strategy returns the strategy of the template, it must be called with the client lock held
+func (k *Worker) strategy(t *board.Template) Strategy {
+	if s, ok := k.strategies[t]; ok {
+		return s
+	}
+
+	s, err := NewStrategy(t.Strategy, k.rand)
+	if err != nil {
+		s, _ = NewStrategy("random", k.rand)
+	}
+	k.strategies[t] = s
+
+	return s
+}
+
+func (k *Worker) place(c *client.Client, p Pixel) {
+	c.Logger.Info("Placing pixel", zap.Any("point", p.At), zap.String("template", p.Template.Name))
+	result := k.placer.Place(c, p.At, p.Color)
+
+	k.clientLock.Lock()
+	delete(k.placing, c)
+	if result.Placed {
+		k.ledger.Placed(c, p.At, p.Color, k.clock.Now())
+	} else {
+		k.ledger.Release(c, p.At)
+		k.queue.Push(p)
+	}
+	k.handle(c, result)
+	next := k.cooldowns.Next(c)
+	k.clientLock.Unlock()
+
+	event := events.PixelPlaced{Time: k.clock.Now(), Client: c, Username: c.Username, Template: p.Template.Name, Point: p.At, Color: p.Color, Outcome: result.Outcome(), Next: next}
+	if result.Err != nil {
+		event.Error = result.Err.Error()
+	}
+	k.bus.Publish(event)
+
+	notify(k.wake)
+}
+
+// Restore gives a client what the journal says it had before a restart, its recent placements are kept away from the
+// other clients right away, while its cooldown waits for the client to join
+func (k *Worker) Restore(c *client.Client, next time.Time, placed []events.PixelPlaced) {
+	k.clientLock.Lock()
+	defer k.clientLock.Unlock()
+
+	now := k.clock.Now()
+	for _, p := range placed {
+		if p.Time.Add(k.ledger.recent).After(now) {
+			k.ledger.Placed(c, p.Point, p.Color, p.Time)
+		}
+	}
+
+	if k.member(c) {
+		k.restore(c, next, !k.cooldowns.Next(c).IsZero())
+		return
+	}
+	k.restored[c] = next
+}
+
+// restore gives a joined client its cooldown when the server did not tell it, it must be called with the client lock held
+func (k *Worker) restore(c *client.Client, next time.Time, known bool) {
+	if !known && next.After(k.cooldowns.Next(c)) {
+		k.cooldowns.Set(c, next)
+		if !k.placing[c] && !k.paused[c] {
+			k.ready.Remove(c)
+			heap.Push(&k.ready, &readyClient{client: c, next: next})
+		}
+	}
+
+	c.Logger.Debug("Restored from the journal", zap.Time("next", k.cooldowns.Next(c)))
+}
+
+// Bus returns the bus the worker publishes what happens to the pixels, the clients and the templates on
+func (k *Worker) Bus() *events.Bus {
+	return k.bus
+}
+
+// Pause stops giving pixels to the client until it's resumed, a pixel it's placing still lands
+func (k *Worker) Pause(c *client.Client) bool {
+	k.clientLock.Lock()
+	if !k.member(c) || k.paused[c] {
+		k.clientLock.Unlock()
+		return false
+	}
+	k.paused[c] = true
+	k.ready.Remove(c)
+	k.clientLock.Unlock()
+
+	c.Logger.Info("Client paused")
+	k.bus.Publish(events.ClientChanged{Time: k.clock.Now(), Client: c, Username: c.Username, Change: "paused"})
+	return true
+}
+
+// Resume gives pixels to a paused client again
+func (k *Worker) Resume(c *client.Client) bool {
+	k.clientLock.Lock()
+	if !k.paused[c] {
+		k.clientLock.Unlock()
+		return false
+	}
+	delete(k.paused, c)
+	if k.member(c) && !k.placing[c] {
+		heap.Push(&k.ready, &readyClient{client: c, next: k.cooldowns.Next(c)})
+	}
+	k.clientLock.Unlock()
+
+	c.Logger.Info("Client resumed")
+	k.bus.Publish(events.ClientChanged{Time: k.clock.Now(), Client: c, Username: c.Username, Change: "resumed"})
+	notify(k.wake)
+	return true
+}
+
+// Paused reports if the client was paused
+func (k *Worker) Paused(c *client.Client) bool {
+	k.clientLock.Lock()
+	defer k.clientLock.Unlock()
+
+	return k.paused[c]
+}
+
+// NextAvailable returns when the client can place again
+func (k *Worker) NextAvailable(c *client.Client) time.Time {
+	k.clientLock.Lock()
+	defer k.clientLock.Unlock()
+
+	return k.cooldowns.Next(c)
+}
+
+// QueueLength counts the pixels of the enabled templates waiting for a client
+func (k *Worker) QueueLength() int {
+	k.clientLock.Lock()
+	defer k.clientLock.Unlock()
+
+	return k.queue.Len()
+}
+
+// SetTemplateEnabled stops or resumes drawing a template, the pixels already leased are still placed
+func (k *Worker) SetTemplateEnabled(t *board.Template, enabled bool) {
+	k.clientLock.Lock()
+	if enabled {
+		delete(k.queue.disabled, t)
+	} else {
+		k.queue.disabled[t] = true
+	}
+	k.clientLock.Unlock()
+
+	event := events.TemplateChanged{Time: k.clock.Now(), Template: t.Name, Change: "disabled"}
+	if enabled {
+		event.Change = "enabled"
+	}
+	k.bus.Publish(event)
+	notify(k.wake)
+}
+
+// TemplateEnabled reports if the clients draw the template
+func (k *Worker) TemplateEnabled(t *board.Template) bool {
+	k.clientLock.Lock()
+	defer k.clientLock.Unlock()
+
+	return !k.queue.disabled[t]
+}
+
+// ReloadTemplates reads the images of the templates again and rebuilds the work queue from them
+func (k *Worker) ReloadTemplates() error {
+	if err := k.board.ReloadTemplates(); err != nil {
+		return err
+	}
+
+	k.bus.Publish(events.TemplateChanged{Time: k.clock.Now(), Change: "reloaded"})
+	notify(k.changes)
+	return nil
+}
+
+// onCanvas wakes the worker up when a frame changed the canvas, and reports the pixels of the templates that were damaged
+func (k *Worker) onCanvas(changed map[board.Point]board.Color) {
+	now := k.clock.Now()
+	k.bus.Publish(events.FrameApplied{Time: now, Changed: len(changed)})
+
+	if k.primed.Swap(true) { // The first frame fills the board, it's not damage
+		for at, color := range changed {
+			if expected, ok := k.board.RequiredColor(at); ok && expected != color {
+				template := ""
+				if t := k.board.TemplateOf(at); t != nil {
+					template = t.Name
 				}
+				k.bus.Publish(events.PixelDamaged{Time: now, Template: template, Point: at, Expected: expected, Actual: color})
 			}
 		}
 	}
+
+	notify(k.changes)
+}
+
+// refresh rebuilds the work queue from the canvas
+func (k *Worker) refresh() {
+	changed := k.board.GetDifferentData()
+
+	k.clientLock.Lock()
+	defer k.clientLock.Unlock()
+
+	k.ledger.Prune(k.clock.Now())
+	k.queue.Reset(k.board, changed, k.clock.Now())
+}
+
+// handle reacts to the outcome of a placement and puts the client back in the heap, it must be called with the client lock held
+func (k *Worker) handle(c *client.Client, result client.PlaceResult) {
+	k.cooldowns.Observe(c, result)
+
+	before := c.CurrentStatus()
+	if c.Observe(result, k.clock.Now()) {
+		after := c.CurrentStatus()
+		k.bus.Publish(events.ClientStateChanged{Time: k.clock.Now(), Client: c, Username: c.Username, From: before.State, To: after.State, Reason: after.Reason})
+	}
+
+	if result.Placed {
+		k.verifier.Track(c, result)
+	}
+
+	switch result.Kind {
+	case client.Transport:
+		c.Logger.Warn("Could not reach the server", zap.Error(result.Err))
+		k.cooldowns.Set(c, k.clock.Now().Add(5*time.Second))
+	case client.Protocol:
+		c.Logger.Warn("Unexpected answer from the server", zap.Error(result.Err))
+		k.cooldowns.Set(c, k.clock.Now().Add(30*time.Second))
+	case client.Banned:
+		c.Logger.Error("Account has been banned from r/place, removing it")
+	case client.Unverified:
+		c.Logger.Error("Account does not have access to r/place due to not being email verified, removing it")
+	case client.AuthExpired:
+		c.Logger.Error("Access token has expired, removing the account until the next login", zap.Error(result.Err))
+	}
+
+	if !result.Usable() {
+		k.leave(c)
+		return
+	}
+
+	if k.member(c) && !k.paused[c] { // The client may have left while it was placing
+		heap.Push(&k.ready, &readyClient{client: c, next: k.cooldowns.Next(c)})
+	}
+}
+
+// leave removes the client and gives its leases and the board to the others, it must be called with the client lock held
+func (k *Worker) leave(c *client.Client) {
+	if !k.member(c) {
+		return
+	}
+
+	k.cooldowns.Forget(c)
+	k.ready.Remove(c)
+	delete(k.paused, c)
+
+	for i, cl := range k.clients {
+		if cl == c {
+			k.clients = append(k.clients[:i], k.clients[i+1:]...)
+			break
+		}
+	}
+
+	for _, p := range k.ledger.ReleaseClient(c) {
+		p.Template = k.board.TemplateOf(p.At)
+		k.queue.Push(p)
+	}
+
+	if k.board.ReleaseController(c) && len(k.clients) > 0 {
+		k.board.SetController(k.clients[0])
+	}
+
+	c.Close()
+	c.Logger.Info("Client left the worker")
+	k.bus.Publish(events.ClientChanged{Time: k.clock.Now(), Client: c, Username: c.Username, Change: "left"})
+}
+
+// requeue puts a pixel that never landed back in the work queue
+func (k *Worker) requeue(at board.Point, color board.Color) {
+	k.clientLock.Lock()
+	k.ledger.Forget(at)
+	k.queue.Push(Pixel{At: at, Color: color, Template: k.board.TemplateOf(at)})
+	k.clientLock.Unlock()
+
+	notify(k.wake)
 }
 
-func splitMap(data map[board2.Point]board2.Color, n int) []map[board2.Point]board2.Color {
-	split := make([]map[board2.Point]board2.Color, n)
-	i := 0
-	for k, v := range data {
-		split[i%n] = make(map[board2.Point]board2.Color)
-		split[i%n][k] = v
-		i++
+// notify signals a channel without blocking, a pending signal is enough
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
 	}
-	return split
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Edouard127/redditplacebot/board"
+	"github.com/Edouard127/redditplacebot/client"
+	"github.com/Edouard127/redditplacebot/events"
+	"github.com/Edouard127/redditplacebot/util"
+	"github.com/Edouard127/redditplacebot/web"
+	"github.com/sergeymakinen/go-bmp"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC)
+
+// testController feeds the board in the tests, like the simulator does
+type testController struct{}
+
+func (testController) Info(string, ...zap.Field) {}
+
+// testPlacer answers the worker and the verifier without a server, the cooldowns and the history are set by the tests
+type testPlacer struct {
+	mu          sync.Mutex
+	cooldowns   map[string]time.Time // By username
+	cooldownErr error
+	history     map[board.Point]web.LastModified
+	historyErr  error
+	lookups     int
+}
+
+func newTestPlacer() *testPlacer {
+	return &testPlacer{cooldowns: make(map[string]time.Time), history: make(map[board.Point]web.LastModified)}
+}
+
+func (p *testPlacer) Place(c *client.Client, at board.Point, color board.Color) client.PlaceResult {
+	return client.PlaceResult{Point: at, Color: color, Placed: true}
+}
+
+func (p *testPlacer) GetCooldown(c *client.Client) client.PlaceResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cooldownErr != nil {
+		return client.PlaceResult{Kind: client.Transport, Err: p.cooldownErr}
+	}
+	return client.PlaceResult{NextAvailable: p.cooldowns[c.Username]}
+}
+
+func (p *testPlacer) GetPlaceHistory(_ *client.Client, at board.Point) (web.LastModified, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lookups++
+	if p.historyErr != nil {
+		return web.LastModified{}, p.historyErr
+	}
+	return p.history[at], nil
+}
+
+// testBoard returns a board with a white template of the given size at the origin, over a black canvas
+func testBoard(t *testing.T, clock util.Clock, width, height int) *board.Board {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.White)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "template.bmp")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = bmp.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+
+	b := board.NewBoard(board.NewTemplate("test", path, board.Point{}, "random"))
+	b.Clock = clock
+	b.SetController(testController{})
+	b.SetColors(testController{}, board.Palette())
+
+	black := make(map[board.Point]board.Color, width*height)
+	for point := range b.RequiredData.Colors {
+		black[point] = board.Colors[27]
+	}
+	b.SetPixels(testController{}, black)
+
+	return b
+}
+
+func testClients(clock util.Clock, n int) []*client.Client {
+	clients := make([]*client.Client, n)
+	for i := range clients {
+		clients[i] = &client.Client{Logger: zap.NewNop(), Username: fmt.Sprintf("client-%d", i), Clock: clock}
+	}
+	return clients
+}
+
+func TestDispatchOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		pixels    int
+		cooldowns []time.Duration // When every client can place, from the start
+		advance   time.Duration
+		want      []int         // The clients given a pixel, in order
+		wantNext  time.Duration // When the next client is ready, zero when none is waiting for work
+	}{
+		{name: "every client ready", pixels: 4, cooldowns: []time.Duration{-3 * time.Second, -2 * time.Second, -time.Second}, want: []int{0, 1, 2}},
+		{name: "earliest cooldown first", pixels: 4, cooldowns: []time.Duration{20 * time.Second, 5 * time.Second, 10 * time.Second}, advance: 30 * time.Second, want: []int{1, 2, 0}},
+		{name: "only the ready clients", pixels: 4, cooldowns: []time.Duration{-time.Second, 10 * time.Second, 20 * time.Second}, want: []int{0}, wantNext: 10 * time.Second},
+		{name: "nobody ready", pixels: 4, cooldowns: []time.Duration{10 * time.Second, 20 * time.Second}, want: []int{}, wantNext: 10 * time.Second},
+		{name: "more clients than pixels", pixels: 2, cooldowns: []time.Duration{-3 * time.Second, -2 * time.Second, -time.Second}, want: []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := util.NewFakeClock(testStart)
+			b := testBoard(t, clock, tt.pixels, 1)
+			placer := newTestPlacer()
+			k := NewWorker(b, placer, clock, util.NewRand(1), DefaultSettings)
+
+			clients := testClients(clock, len(tt.cooldowns))
+			for i, c := range clients {
+				placer.cooldowns[c.Username] = testStart.Add(tt.cooldowns[i])
+			}
+			k.ClientJoin(clients...)
+			k.refresh()
+
+			clock.Advance(tt.advance)
+			jobs, next := k.dispatchReady()
+
+			got := make([]int, len(jobs))
+			for i, j := range jobs {
+				for index, c := range clients {
+					if j.client == c {
+						got[i] = index
+					}
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("dispatched to %v, want %v", got, tt.want)
+			}
+
+			wantNext := time.Time{}
+			if tt.wantNext != 0 {
+				wantNext = testStart.Add(tt.wantNext)
+			}
+			if !next.Equal(wantNext) {
+				t.Errorf("next = %v, want %v", next, wantNext)
+			}
+
+			leased := make(map[board.Point]bool)
+			for _, j := range jobs {
+				if leased[j.pixel.At] {
+					t.Errorf("%v was given to two clients", j.pixel.At)
+				}
+				leased[j.pixel.At] = true
+			}
+		})
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance time.Duration // After the first client got the pixel
+		want    bool          // The second client gets the pixel
+	}{
+		{name: "lease held", advance: 30 * time.Second, want: false},
+		{name: "lease about to expire", advance: DefaultSettings.LeaseTTL - time.Second, want: false},
+		{name: "lease expired", advance: DefaultSettings.LeaseTTL, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := util.NewFakeClock(testStart)
+			b := testBoard(t, clock, 1, 1)
+			placer := newTestPlacer()
+			k := NewWorker(b, placer, clock, util.NewRand(1), DefaultSettings)
+
+			clients := testClients(clock, 2)
+			placer.cooldowns[clients[1].Username] = testStart.Add(tt.advance) // Ready when the test looks again
+			k.ClientJoin(clients...)
+			k.refresh()
+
+			jobs, _ := k.dispatchReady()
+			if len(jobs) != 1 || jobs[0].client != clients[0] {
+				t.Fatalf("the first client should get the only pixel, got %d jobs", len(jobs))
+			}
+
+			clock.Advance(tt.advance)
+			k.refresh() // The first client never answered, the pixel is still mismatched
+			jobs, _ = k.dispatchReady()
+
+			got := len(jobs) == 1 && jobs[0].client == clients[1]
+			if got != tt.want {
+				t.Errorf("second client got the pixel = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	leasedAt, placedAt, white := board.Point{}, board.Point{X: 1}, board.Colors[31]
+	journaled := testStart.Add(5 * time.Minute)
+
+	tests := []struct {
+		name        string
+		join        bool
+		cooldownErr error
+		wantNext    time.Time
+	}{
+		{name: "not joined", join: false},
+		{name: "server cooldown wins", join: true, wantNext: time.Time{}},
+		{name: "journal cooldown when the server can't tell", join: true, cooldownErr: errors.New("connection reset"), wantNext: journaled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := util.NewFakeClock(testStart)
+			b := testBoard(t, clock, 2, 1)
+			placer := newTestPlacer()
+			placer.cooldownErr = tt.cooldownErr
+			k := NewWorker(b, placer, clock, util.NewRand(1), DefaultSettings)
+			k.refresh()
+
+			c := testClients(clock, 1)[0]
+			j, err := OpenJournal(zap.NewNop(), filepath.Join(t.TempDir(), "journal.jsonl"), 1<<20, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.Close()
+
+			before := testStart.Add(-time.Second)
+			for _, e := range []events.Event{
+				events.PixelLeased{Time: before, Username: c.Username, Point: placedAt, Color: white, Expires: testStart.Add(time.Minute)},
+				events.PixelPlaced{Time: before, Username: c.Username, Point: placedAt, Color: white, Outcome: "placed", Next: journaled},
+				events.PixelLeased{Time: before, Username: c.Username, Point: leasedAt, Color: white, Expires: testStart.Add(time.Minute)},
+			} {
+				if err = j.Write(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err = restoreJournal(j, k, newPlacementLog(events.NewBus(), 16), []*client.Client{c}); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.join {
+				k.ClientJoin(c)
+			}
+
+			if k.ledger.Available(placedAt, white, clock.Now()) {
+				t.Errorf("the pixel placed before the restart can be placed again")
+			}
+			if !k.ledger.Available(leasedAt, white, clock.Now()) {
+				t.Errorf("the pixel leased before the restart is hidden from the clients")
+			}
+
+			if !tt.join {
+				return
+			}
+
+			if next := k.cooldowns.Next(c); !next.Equal(tt.wantNext) {
+				t.Errorf("next = %v, want %v", next, tt.wantNext)
+			}
+
+			if !tt.wantNext.IsZero() {
+				return
+			}
+
+			jobs, _ := k.dispatchReady()
+			if len(jobs) != 1 || jobs[0].pixel.At != leasedAt {
+				t.Errorf("dispatched %v, want the pixel leased before the restart", jobs)
+			}
+		})
+	}
+}